@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
@@ -41,7 +42,7 @@ type Gomon struct {
 	Retransmitter
 
 	enabled          bool
-	started          bool
+	started          int32
 	applicationScope EventTracker
 	oldAppScope      EventTracker
 
@@ -62,20 +63,26 @@ var gomon = Gomon{
 		listeners:         make([]Listener, 0, 3),
 	},
 	enabled:          true,
-	started:          false,
+	started:          0,
 	applicationScope: nil,
 	configSetters:    make(map[string]ConfigSetterFunc),
 	temporalConfigs:  make(map[string]TrackerConfig),
 }
 
 func (g *Gomon) Start() {
-	g.started = true
+	atomic.StoreInt32(&g.started, 1)
 	if g.applicationScope.AppID() == nil {
 		g.applicationScope.SetAppID(uuid.New().String())
 	}
 	g.Feed(g.applicationScope)
 }
 
+// Started reports whether Start was called, events
+// must not be fed before that
+func (g *Gomon) Started() bool {
+	return atomic.LoadInt32(&g.started) == 1
+}
+
 func (g *Gomon) SetApplicationID(identifier string) {
 	g.applicationScope.SetAppID(identifier)
 }
@@ -122,8 +129,8 @@ func (g *Gomon) FromContext(ctx context.Context) EventTracker {
 		return g.applicationScope
 	}
 
-	parent := ctx.Value(eventTrackerKey{}).(EventTracker)
-	if parent != nil {
+	parent, ok := ctx.Value(eventTrackerKey{}).(EventTracker)
+	if ok && parent != nil {
 		return parent
 	}
 
@@ -131,7 +138,7 @@ func (g *Gomon) FromContext(ctx context.Context) EventTracker {
 }
 
 func (g *Gomon) Feed(et EventTracker) {
-	if !g.started {
+	if !g.Started() {
 		panic("monitoring not started but received event")
 	} else {
 		go g.Retransmitter.Feed(et)
@@ -158,12 +165,16 @@ func (g *Gomon) Toggle() {
 }
 
 func (g *Retransmitter) Feed(et EventTracker) {
+	// events are fed from multiple goroutines
+	g.listenersMu.Lock()
 	// too dummy for production
 	if g.applicationScope == nil {
 		g.applicationScope = et
 	}
+	listeners := g.listeners
+	g.listenersMu.Unlock()
 
-	for _, x := range g.listeners {
+	for _, x := range listeners {
 		x.Feed(et)
 	}
 }
@@ -171,10 +182,11 @@ func (g *Retransmitter) Feed(et EventTracker) {
 func (g *Retransmitter) AddListener(listener Listener) {
 	g.listenersMu.Lock()
 	g.listeners = append(g.listeners, listener)
+	applicationScope := g.applicationScope
 	g.listenersMu.Unlock()
 
-	if g.applicationScope != nil {
-		listener.Feed(g.applicationScope)
+	if applicationScope != nil {
+		listener.Feed(applicationScope)
 	}
 }
 
@@ -222,6 +234,10 @@ func Start() {
 	gomon.Start()
 }
 
+func Started() bool {
+	return gomon.Started()
+}
+
 func SetApplicationID(identifier string) {
 	gomon.SetApplicationID(identifier)
 }
@@ -0,0 +1,147 @@
+package gomon
+
+import (
+	"sync"
+	"time"
+)
+
+// Histogram is a concurrency safe fixed bucket histogram of durations,
+// used by plugins for aggregating latencies between reports
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []time.Duration
+	counts  []int64
+	count   int64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+// DefaultBuckets covers typical network/database latencies
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// NewHistogram creates histogram with given upper bounds (sorted ascending),
+// values bigger than the last bound are counted in overflow bucket
+func NewHistogram(buckets []time.Duration) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)+1),
+	}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for ; i < len(h.buckets); i++ {
+		if d <= h.buckets[i] {
+			break
+		}
+	}
+	h.counts[i]++
+
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Quantile estimates q-th quantile (0 < q <= 1) using
+// upper bound of the bucket where it falls
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.quantile(q)
+}
+
+func (h *Histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i < len(h.buckets) && h.buckets[i] < h.max {
+				return h.buckets[i]
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// KVData returns histogram state in a form suitable for EventTracker.Set
+func (h *Histogram) KVData() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make(map[string]int64, len(h.counts))
+	for i, c := range h.counts {
+		if i < len(h.buckets) {
+			buckets[h.buckets[i].String()] = c
+		} else {
+			buckets["+Inf"] = c
+		}
+	}
+
+	kv := make(map[string]interface{})
+	kv["count"] = h.count
+	kv["sum"] = h.sum
+	kv["min"] = h.min
+	kv["max"] = h.max
+	kv["p50"] = h.quantile(0.5)
+	kv["p95"] = h.quantile(0.95)
+	kv["p99"] = h.quantile(0.99)
+	kv["buckets"] = buckets
+	if h.count > 0 {
+		kv["avg"] = h.sum / time.Duration(h.count)
+	}
+	return kv
+}
+
+// Reset clears collected values, buckets are kept
+func (h *Histogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count = 0
+	h.sum = 0
+	h.min = 0
+	h.max = 0
+}
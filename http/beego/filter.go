@@ -0,0 +1,113 @@
+package beego
+
+import (
+	"net/http"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/iahmedov/gomon"
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+func init() {
+	gomon.SetConfigFunc(pluginName, SetConfig)
+}
+
+// PluginConfig is the same as gomon/http.PluginConfig,
+// separate type lets beego be configured independently
+type PluginConfig struct {
+	gomonhttp.PluginConfig
+}
+
+var defaultConfig = &PluginConfig{
+	gomonhttp.PluginConfig{
+		RequestHeaders:  true,
+		RespBody:        true,
+		RespBodyMaxSize: 1024,
+		RespHeaders:     true,
+		RespCode:        true,
+	},
+}
+
+// config set by SetConfig, loaded once per request
+var currentConfig atomic.Value // *PluginConfig
+
+var pluginName = "http-beego"
+
+var (
+	KeyController = "controller"
+	KeyAction     = "action"
+)
+
+func SetConfig(c gomon.TrackerConfig) {
+	if conf, ok := c.(*PluginConfig); ok {
+		currentConfig.Store(conf)
+	} else {
+		panic("not compatible config")
+	}
+}
+
+func loadConfig() *PluginConfig {
+	if conf, ok := currentConfig.Load().(*PluginConfig); ok {
+		return conf
+	}
+	return defaultConfig
+}
+
+func (p *PluginConfig) Name() string {
+	return pluginName
+}
+
+// Run is beego.Run with monitoring, it is the same as
+// calling InsertFilters and beego.RunWithMiddleWares(addr, Monitoring)
+func Run(addr string, mws ...beego.MiddleWare) {
+	InsertFilters()
+	beego.RunWithMiddleWares(addr, append([]beego.MiddleWare{Monitoring}, mws...)...)
+}
+
+// Monitoring tracks whole request, including static files and
+// requests finished by filters, it should be the first middleware
+func Monitoring(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, monitor := loadConfig().ForRequest(r)
+		if !monitor {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		et, w, r := gomonhttp.StartIncomingRequest(w, r, config)
+		et.SetFingerprint("beego-handle")
+		defer et.Finish()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InsertFilters adds filter which sets route pattern, controller and
+// action of the executed router to request tracker
+func InsertFilters() {
+	beego.InsertFilter("*", beego.AfterExec, ActionFilter, false)
+}
+
+func ActionFilter(ctx *context.Context) {
+	if !gomon.HasTracker(ctx.Request.Context()) {
+		// request is not monitored
+		return
+	}
+	et := gomon.FromContext(ctx.Request.Context())
+
+	if pattern, ok := ctx.Input.GetData("RouterPattern").(string); ok {
+		et.Set(gomonhttp.KeyRoute, pattern)
+	}
+	if t := ctx.Input.RunController; t != nil {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		et.Set(KeyController, t.Name())
+	}
+	if len(ctx.Input.RunMethod) > 0 {
+		et.Set(KeyAction, ctx.Input.RunMethod)
+	}
+}
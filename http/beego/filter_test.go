@@ -0,0 +1,57 @@
+package beego
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/astaxie/beego/context"
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+type UserController struct{}
+
+// router emulates beego router, it runs AfterExec filter
+// once controller method is executed
+func router(w http.ResponseWriter, r *http.Request) {
+	ctx := context.NewContext()
+	ctx.Reset(w, r)
+	ctx.Input.SetData("RouterPattern", "/users/:id")
+	ctx.Input.RunController = reflect.TypeOf(&UserController{})
+	ctx.Input.RunMethod = "Get"
+	w.Write([]byte("ok"))
+	ActionFilter(ctx)
+}
+
+func TestActionFilter(t *testing.T) {
+	events.reset()
+	srv := httptest.NewServer(Monitoring(http.HandlerFunc(router)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/users/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	et := events.wait(t, "beego-handle", 1)[0]
+	if route := et.Get(gomonhttp.KeyRoute); route != "/users/:id" {
+		t.Errorf("route = %v, want /users/:id", route)
+	}
+	if controller := et.Get(KeyController); controller != "UserController" {
+		t.Errorf("controller = %v, want UserController", controller)
+	}
+	if action := et.Get(KeyAction); action != "Get" {
+		t.Errorf("action = %v, want Get", action)
+	}
+}
+
+func TestActionFilterNotMonitored(t *testing.T) {
+	// request without tracker is left untouched
+	w := httptest.NewRecorder()
+	router(w, httptest.NewRequest("GET", "/users/5", nil))
+	if w.Body.String() != "ok" {
+		t.Errorf("body = %q, want ok", w.Body.String())
+	}
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// wrappedResponseBody keeps outgoing request tracker open until
+// response body is closed, so that download size and time
+// are part of the same event. Tracker is written through
+// trace.update, transport hooks write it concurrently
+type wrappedResponseBody struct {
+	parent io.ReadCloser
+	trace  *httpTraceWriterEventTracker
+
+	// guards read stats
+	mu       sync.Mutex
+	once     sync.Once
+	opened   time.Time
+	readSize int64
+	readTime time.Duration
+	eof      bool
+}
+
+var (
+	KeyBodyReadSize  = "body-read-size"
+	KeyBodyReadTime  = "body-read-time"
+	KeyBodyCloseTime = "body-close-time"
+	KeyBodyEOF       = "body-eof"
+	KeyBodyLeaked    = "body-leaked"
+)
+
+var _ io.ReadCloser = (*wrappedResponseBody)(nil)
+
+func monitoredResponseBody(resp *http.Response, config *PluginConfig, trace *httpTraceWriterEventTracker) io.ReadCloser {
+	// 101 Switching Protocols returns io.ReadWriteCloser as a body,
+	// wrapping it would hide Write from the caller
+	if resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		trace.Finish()
+		return resp.Body
+	}
+
+	b := &wrappedResponseBody{
+		parent: resp.Body,
+		trace:  trace,
+		opened: time.Now(),
+	}
+
+	if config.RespBodyLeakDetection {
+		runtime.SetFinalizer(b, (*wrappedResponseBody).leaked)
+	}
+
+	return b
+}
+
+func (b *wrappedResponseBody) Read(p []byte) (n int, err error) {
+	start := time.Now()
+	n, err = b.parent.Read(p)
+
+	b.mu.Lock()
+	b.readTime += time.Since(start)
+	b.readSize += int64(n)
+	if err == io.EOF {
+		b.eof = true
+	}
+	b.mu.Unlock()
+	if err != nil && err != io.EOF {
+		b.addError(err)
+	}
+	return
+}
+
+func (b *wrappedResponseBody) Close() (err error) {
+	err = b.parent.Close()
+	if err != nil {
+		b.addError(err)
+	}
+
+	runtime.SetFinalizer(b, nil)
+	b.finish(false)
+	return
+}
+
+func (b *wrappedResponseBody) leaked() {
+	// body is unreachable, closing it returns connection
+	// back to the pool which otherwise would be lost
+	b.parent.Close()
+	b.finish(true)
+}
+
+func (b *wrappedResponseBody) addError(err error) {
+	b.trace.update(func(et gomon.EventTracker) {
+		et.AddError(err)
+	})
+}
+
+func (b *wrappedResponseBody) finish(leaked bool) {
+	b.once.Do(func() {
+		b.mu.Lock()
+		readSize, readTime, eof := b.readSize, b.readTime, b.eof
+		b.mu.Unlock()
+
+		b.trace.update(func(et gomon.EventTracker) {
+			et.Set(KeyBodyReadSize, readSize)
+			et.Set(KeyBodyReadTime, readTime)
+			et.Set(KeyBodyCloseTime, time.Since(b.opened))
+			et.Set(KeyBodyEOF, eof)
+			if leaked {
+				et.Set(KeyBodyLeaked, true)
+			}
+		})
+		b.trace.Finish()
+	})
+}
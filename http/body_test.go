@@ -0,0 +1,68 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+func testResponse(fp string, config *PluginConfig) io.ReadCloser {
+	trace := newHttpTraceWriter(gomon.FromContext(nil).NewChild(false))
+	trace.SetFingerprint(fp)
+	resp := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("hello"))}
+	return monitoredResponseBody(resp, config, trace)
+}
+
+func TestResponseBodyClose(t *testing.T) {
+	events.reset()
+	body := testResponse("test-body", &PluginConfig{})
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	body.Close()
+
+	et := events.wait(t, "test-body", 1)[0]
+	if size := et.Get(KeyBodyReadSize); size != int64(5) {
+		t.Errorf("read size = %v, want 5", size)
+	}
+	if eof := et.Get(KeyBodyEOF); eof != true {
+		t.Errorf("eof = %v, want true", eof)
+	}
+	if et.Get(KeyBodyCloseTime) == nil || et.Get(KeyBodyLeaked) != nil {
+		t.Errorf("close time = %v, leaked = %v", et.Get(KeyBodyCloseTime), et.Get(KeyBodyLeaked))
+	}
+
+	// the second Close does not finish tracker again
+	time.Sleep(50 * time.Millisecond)
+	if n := len(events.wait(t, "test-body", 1)); n != 1 {
+		t.Errorf("tracker is finished %d times, want 1", n)
+	}
+}
+
+func TestResponseBodyLeak(t *testing.T) {
+	events.reset()
+	func() {
+		// body is unreachable once function returns
+		body := testResponse("test-body-leak", &PluginConfig{RespBodyLeakDetection: true})
+		body.Read(make([]byte, 2))
+	}()
+	runtime.GC()
+	runtime.GC()
+
+	et := events.wait(t, "test-body-leak", 1)[0]
+	if leaked := et.Get(KeyBodyLeaked); leaked != true {
+		t.Errorf("leaked = %v, want true", leaked)
+	}
+	if size := et.Get(KeyBodyReadSize); size != int64(2) {
+		t.Errorf("read size = %v, want 2", size)
+	}
+	if eof := et.Get(KeyBodyEOF); eof != false {
+		t.Errorf("eof = %v, want false", eof)
+	}
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/iahmedov/gomon"
+)
+
+// clientRoundTripper groups every hop (redirect) of a single
+// http.Client.Do call under one parent tracker.
+// hops are linked through Request.Response.Request, which
+// points to the request of previous hop
+type clientRoundTripper struct {
+	http.RoundTripper
+}
+
+type clientChain struct {
+	et      gomon.EventTracker
+	timings *gomon.Timings
+
+	mu        sync.Mutex
+	once      sync.Once
+	hops      int
+	redirects []map[string]interface{}
+	follow    bool
+}
+
+// retryCall is the logical request, attempts made by
+// retry loop are its children
+type retryCall struct {
+	et gomon.EventTracker
+
+	mu       sync.Mutex
+	once     sync.Once
+	attempts int
+}
+
+// body of the last hop, closing it finishes the chain
+type clientChainBody struct {
+	io.ReadCloser
+	chain *clientChain
+	resp  *http.Response
+}
+
+type clientChainKey struct{}
+type retryAttemptKey struct{}
+type retryCallKey struct{}
+
+var (
+	KeyClientHops      = "hops"
+	KeyClientRedirects = "redirects"
+	KeyRetryAttempt    = "retry-attempt"
+	KeyRetryAttempts   = "retry-attempts"
+)
+
+// copied from net/http, used when client has no CheckRedirect
+const kMaxRedirects = 10
+
+var _ http.RoundTripper = (*clientRoundTripper)(nil)
+
+// WithRetryAttempt marks requests made with returned context as
+// n-th attempt of the same logical request. Attempts are children of
+// "http-client-retries" tracker, it is created by the first call and
+// reused when ctx already has one, so retry loops keep returned context
+// and finish it with FinishRetries:
+//
+//	ctx = gomonhttp.WithRetryAttempt(ctx, 1)
+//	defer gomonhttp.FinishRetries(ctx)
+//	for attempt := 1; ; attempt++ {
+//		ctx = gomonhttp.WithRetryAttempt(ctx, attempt)
+//		...
+//	}
+func WithRetryAttempt(ctx context.Context, attempt int) context.Context {
+	call, ok := ctx.Value(retryCallKey{}).(*retryCall)
+	if !ok {
+		et := gomon.FromContext(ctx).NewChild(false)
+		et.SetFingerprint("http-client-retries")
+		call = &retryCall{et: et}
+		ctx = context.WithValue(ctx, retryCallKey{}, call)
+	}
+	ctx = gomon.WithContext(ctx, call.et)
+	return context.WithValue(ctx, retryAttemptKey{}, attempt)
+}
+
+// FinishRetries finishes logical request started by
+// WithRetryAttempt, it does nothing if ctx has none
+func FinishRetries(ctx context.Context) {
+	if call, ok := ctx.Value(retryCallKey{}).(*retryCall); ok {
+		call.finish()
+	}
+}
+
+func RetryAttempt(ctx context.Context) (attempt int, ok bool) {
+	attempt, ok = ctx.Value(retryAttemptKey{}).(int)
+	return
+}
+
+func chainFromRequest(r *http.Request) *clientChain {
+	if r == nil {
+		return nil
+	}
+	chain, _ := r.Context().Value(clientChainKey{}).(*clientChain)
+	return chain
+}
+
+func newClientChain(r *http.Request) *clientChain {
+	et := OutgoingRequestTracker(r, loadConfig())
+	et.SetFingerprint("http-client")
+	if attempt, ok := RetryAttempt(r.Context()); ok {
+		et.Set(KeyRetryAttempt, attempt)
+	}
+	if call, ok := r.Context().Value(retryCallKey{}).(*retryCall); ok {
+		call.attempt()
+	}
+
+	return &clientChain{
+		et:        et,
+		timings:   gomon.TimingsFromContext(r.Context()),
+		redirects: make([]map[string]interface{}, 0),
+	}
+}
+
+func (c *clientRoundTripper) RoundTrip(r *http.Request) (resp *http.Response, err error) {
+	var chain *clientChain
+	if r.Response != nil {
+		chain = chainFromRequest(r.Response.Request)
+	}
+	if chain == nil {
+		chain = newClientChain(r)
+	}
+	chain.hop()
+
+	ctx := context.WithValue(r.Context(), clientChainKey{}, chain)
+	r = r.WithContext(gomon.WithContext(ctx, chain.et))
+
+	resp, err = c.RoundTripper.RoundTrip(r)
+	if err != nil {
+		chain.et.AddError(err)
+		chain.finish(nil)
+		return
+	}
+
+	if resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		chain.finish(resp)
+		return
+	}
+
+	resp.Body = &clientChainBody{
+		ReadCloser: resp.Body,
+		chain:      chain,
+		resp:       resp,
+	}
+	return
+}
+
+func wrapCheckRedirect(f fncCheckRedirect) fncCheckRedirect {
+	return func(req *http.Request, via []*http.Request) (err error) {
+		if f != nil {
+			err = f(req, via)
+		} else if len(via) >= kMaxRedirects {
+			err = errors.New("stopped after 10 redirects")
+		}
+
+		if req.Response == nil {
+			return
+		}
+
+		if chain := chainFromRequest(req.Response.Request); chain != nil {
+			chain.redirect(req.Response, err)
+		}
+		return
+	}
+}
+
+func (c *clientChain) hop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hops++
+}
+
+func (c *clientChain) redirect(resp *http.Response, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.redirects = append(c.redirects, map[string]interface{}{
+		"status":   resp.StatusCode,
+		"location": resp.Header.Get("Location"),
+		"followed": err == nil,
+	})
+
+	if err == nil {
+		// client closes the body of this hop before sending next one
+		c.follow = true
+	} else if err != http.ErrUseLastResponse {
+		c.et.AddError(err)
+	}
+}
+
+func (c *clientChain) hopClosed(resp *http.Response) {
+	c.mu.Lock()
+	follow := c.follow
+	c.follow = false
+	c.mu.Unlock()
+
+	if !follow {
+		c.finish(resp)
+	}
+}
+
+func (c *clientChain) finish(resp *http.Response) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.et.Set(KeyClientHops, c.hops)
+		if len(c.redirects) > 0 {
+			c.et.Set(KeyClientRedirects, c.redirects)
+		}
+		if resp != nil {
+			c.et.Set("resp-status", resp.StatusCode)
+		}
+		c.et.Finish()
+		c.timings.Add("http", c.et.Lapsed())
+	})
+}
+
+func (c *retryCall) attempt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+}
+
+func (c *retryCall) finish() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.et.Set(KeyRetryAttempts, c.attempts)
+		c.et.Finish()
+	})
+}
+
+func (b *clientChainBody) Close() (err error) {
+	err = b.ReadCloser.Close()
+	b.chain.hopClosed(b.resp)
+	return
+}
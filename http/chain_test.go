@@ -0,0 +1,110 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iahmedov/gomon"
+)
+
+func isChild(et, parent gomon.EventTracker) bool {
+	return et.Parent() != nil && *et.Parent() == parent.ID()
+}
+
+func TestClientRedirectHops(t *testing.T) {
+	events.reset()
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	get(t, MonitoredClient(&http.Client{Transport: transport}), srv.URL+"/a")
+
+	chain := events.waitMatch(t, "http-client", 1, to(srv.Listener.Addr().String()))[0]
+	if hops := chain.Get(KeyClientHops); hops != 2 {
+		t.Errorf("hops = %v, want 2", hops)
+	}
+	if status := chain.Get("resp-status"); status != 200 {
+		t.Errorf("status = %v, want 200 of the last hop", status)
+	}
+	redirects, _ := chain.Get(KeyClientRedirects).([]map[string]interface{})
+	if len(redirects) != 1 || redirects[0]["status"] != http.StatusFound ||
+		redirects[0]["location"] != "/b" || redirects[0]["followed"] != true {
+		t.Errorf("redirects = %v", redirects)
+	}
+
+	// hops are children of client tracker
+	events.waitMatch(t, "http-roundtripper", 2, func(trip gomon.EventTracker) bool {
+		return isChild(trip, chain)
+	})
+}
+
+func TestClientRedirectNotFollowed(t *testing.T) {
+	events.reset()
+	srv := httptest.NewServer(http.RedirectHandler("/b", http.StatusFound))
+	defer srv.Close()
+
+	c := MonitoredClient(&http.Client{
+		Transport: &http.Transport{},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	})
+	get(t, c, srv.URL)
+
+	chain := events.waitMatch(t, "http-client", 1, to(srv.Listener.Addr().String()))[0]
+	if hops := chain.Get(KeyClientHops); hops != 1 {
+		t.Errorf("hops = %v, want 1", hops)
+	}
+	if status := chain.Get("resp-status"); status != http.StatusFound {
+		t.Errorf("status = %v, want 302", status)
+	}
+	// ErrUseLastResponse is not a failure
+	if errs := chain.Get(gomon.KeyErrors); errs != nil {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	events.reset()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	c := MonitoredClient(&http.Client{Transport: &http.Transport{}})
+
+	ctx := WithRetryAttempt(context.Background(), 1)
+	for attempt := 1; attempt <= 3; attempt++ {
+		ctx = WithRetryAttempt(ctx, attempt)
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	FinishRetries(ctx)
+	FinishRetries(ctx)
+
+	retries := events.wait(t, "http-client-retries", 1)
+	if len(retries) != 1 {
+		t.Fatalf("got %d retries trackers, want 1", len(retries))
+	}
+	if n := retries[0].Get(KeyRetryAttempts); n != 3 {
+		t.Errorf("attempts = %v, want 3", n)
+	}
+
+	seen := map[interface{}]bool{}
+	attempts := events.waitMatch(t, "http-client", 3, func(et gomon.EventTracker) bool {
+		return isChild(et, retries[0])
+	})
+	for _, et := range attempts {
+		seen[et.Get(KeyRetryAttempt)] = true
+	}
+	if !seen[1] || !seen[2] || !seen[3] {
+		t.Errorf("attempts = %v, want 1, 2 and 3", seen)
+	}
+}
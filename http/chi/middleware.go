@@ -0,0 +1,94 @@
+package chi
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-chi/chi"
+	"github.com/iahmedov/gomon"
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+func init() {
+	gomon.SetConfigFunc(pluginName, SetConfig)
+}
+
+type PluginConfig struct {
+	gomonhttp.PluginConfig
+	// path params may contain user data, so they are opt-in
+	PathParams bool
+}
+
+var defaultConfig = &PluginConfig{
+	PluginConfig: gomonhttp.PluginConfig{
+		RequestHeaders:  true,
+		RespBody:        true,
+		RespBodyMaxSize: 1024,
+		RespHeaders:     true,
+		RespCode:        true,
+	},
+}
+
+// config set by SetConfig, loaded once per request
+var currentConfig atomic.Value // *PluginConfig
+
+var pluginName = "http-chi"
+
+var (
+	KeyPathParams = "path-params"
+)
+
+func SetConfig(c gomon.TrackerConfig) {
+	if conf, ok := c.(*PluginConfig); ok {
+		currentConfig.Store(conf)
+	} else {
+		panic("not compatible config")
+	}
+}
+
+func loadConfig() *PluginConfig {
+	if conf, ok := currentConfig.Load().(*PluginConfig); ok {
+		return conf
+	}
+	return defaultConfig
+}
+
+func (p *PluginConfig) Name() string {
+	return pluginName
+}
+
+// Monitoring is chi middleware, r.Use(gomonchi.Monitoring)
+func Monitoring(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := loadConfig()
+		config, monitor := conf.ForRequest(r)
+		if !monitor {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		et, w, r := gomonhttp.StartIncomingRequest(w, r, config)
+		et.SetFingerprint("chi-handle")
+		defer et.Finish()
+
+		next.ServeHTTP(w, r)
+
+		// route is known only after request is routed
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			return
+		}
+		if pattern := rctx.RoutePattern(); len(pattern) > 0 {
+			et.Set(gomonhttp.KeyRoute, pattern)
+		}
+		if conf.PathParams && len(rctx.URLParams.Keys) > 0 {
+			params := make(map[string]string)
+			for i, k := range rctx.URLParams.Keys {
+				if i < len(rctx.URLParams.Values) {
+					params[k] = rctx.URLParams.Values[i]
+				}
+			}
+			et.Set(KeyPathParams, params)
+		}
+	})
+}
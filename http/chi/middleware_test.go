@@ -0,0 +1,49 @@
+package chi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+func TestMonitoringRoute(t *testing.T) {
+	defer SetConfig(defaultConfig)
+	r := chi.NewRouter()
+	r.Use(Monitoring)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	for _, pathParams := range []bool{false, true} {
+		events.reset()
+		SetConfig(&PluginConfig{
+			PluginConfig: gomonhttp.PluginConfig{RespCode: true},
+			PathParams:   pathParams,
+		})
+		resp, err := http.Get(srv.URL + "/users/5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		et := events.wait(t, "chi-handle", 1)[0]
+		if route := et.Get(gomonhttp.KeyRoute); route != "/users/{id}" {
+			t.Errorf("route = %v, want /users/{id}", route)
+		}
+		if code := et.Get(gomonhttp.KeyResponseCode); code != http.StatusOK {
+			t.Errorf("response code = %v, want 200", code)
+		}
+		params, _ := et.Get(KeyPathParams).(map[string]string)
+		if pathParams && (len(params) != 1 || params["id"] != "5") {
+			t.Errorf("path params = %v, want id=5", params)
+		} else if !pathParams && params != nil {
+			t.Errorf("path params = %v, want none by default", params)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
-	"time"
 
 	"github.com/iahmedov/gomon"
 	gomonnet "github.com/iahmedov/gomon/net"
@@ -17,22 +16,19 @@ type wrappedRoundTripper struct {
 	http.RoundTripper
 }
 
-type httpTraceWriterEventTracker struct {
-	gomon.EventTracker
-}
-
 type fncProxy func(*http.Request) (*url.URL, error)
 type fncDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
 type fncDial func(network, addr string) (net.Conn, error)
 type fncDialTLS func(network, addr string) (net.Conn, error)
 type fncNextProto func(authority string, c *tls.Conn) http.RoundTripper
+type fncCheckRedirect func(req *http.Request, via []*http.Request) error
 
 var _ http.RoundTripper = (*wrappedRoundTripper)(nil)
 
 func AutoRegister() {
 	http.DefaultClient = MonitoredClient(http.DefaultClient)
 	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
-		http.DefaultTransport = MonitoredTransport(transport)
+		http.DefaultTransport = MonitoredNamedTransport("default", transport)
 	}
 	http.DefaultTransport = MonitoredRoundTripper(http.DefaultTransport)
 }
@@ -41,6 +37,11 @@ func MonitoredRoundTripper(roundTripper http.RoundTripper) http.RoundTripper {
 	return &wrappedRoundTripper{roundTripper}
 }
 
+// Unwrap returns monitored round tripper
+func (w *wrappedRoundTripper) Unwrap() http.RoundTripper {
+	return w.RoundTripper
+}
+
 func MonitoredClient(client *http.Client) (c *http.Client) {
 	tmp := *client
 	c = &tmp
@@ -49,20 +50,44 @@ func MonitoredClient(client *http.Client) (c *http.Client) {
 	} else {
 		c.Transport = MonitoredRoundTripper(c.Transport)
 	}
+	c.Transport = &clientRoundTripper{c.Transport}
+	c.CheckRedirect = wrapCheckRedirect(c.CheckRedirect)
 	return
 }
 
 func MonitoredTransport(transport *http.Transport) *http.Transport {
+	return MonitoredNamedTransport("", transport)
+}
+
+// MonitoredNamedTransport is same as MonitoredTransport, given name is
+// attached to periodic connection pool reports of this transport
+func MonitoredNamedTransport(name string, transport *http.Transport) *http.Transport {
 	t := *transport
+	if t.DialContext == nil && t.Dial == nil {
+		// same as what http.Transport uses internally,
+		// set explicitly so that dialed connections are visible
+		t.DialContext = (&net.Dialer{}).DialContext
+	}
+
+	config := loadConfig()
+	var pool *transportPool
+	if config.PoolStatInterval > 0 {
+		pool = newTransportPool(name)
+	}
 	t.Proxy = wrapTransportProxy(t.Proxy)
-	t.DialContext = wrapTransportDialContext(t.DialContext)
-	t.Dial = wrapTransportDial(t.Dial)
-	t.DialTLS = wrapTransportDialTLS(t.DialTLS)
+	t.DialContext = pool.wrapDialContext(wrapTransportDialContext(t.DialContext))
+	t.Dial = pool.wrapDial(wrapTransportDial(t.Dial))
+	t.DialTLS = pool.wrapDialTLS(wrapTransportDialTLS(t.DialTLS))
 
 	for k, v := range transport.TLSNextProto {
 		t.TLSNextProto[k] = wrapTransportNextProto(v)
 	}
 
+	if pool != nil {
+		transportPools.Store(&t, pool)
+		go pool.run(config.PoolStatInterval)
+	}
+
 	return &t
 }
 
@@ -77,32 +102,32 @@ func (w *wrappedRoundTripper) RoundTrip(r *http.Request) (resp *http.Response, e
 	// all of the httptrace + internal/nettrace logic will be run
 	// inside the DefaultTransport (RoundTripper)
 	// thats why its ok to put httptrace related things here
-	et := OutgoingRequestTracker(r, defaultConfig)
-
-	traceWriter := &httpTraceWriterEventTracker{et}
-
-	trace := &httptrace.ClientTrace{
-		GetConn:              traceWriter.GetConn,
-		GotFirstResponseByte: traceWriter.GotFirstResponseByte,
-		// sometimes when DNSStart and DNSDone enabled requests were too slow
-		// maybe it was random network issues on my side when testing
-		// if it happens again investigate
-		DNSStart:          traceWriter.DNSStart,
-		DNSDone:           traceWriter.DNSDone,
-		ConnectStart:      traceWriter.ConnectStart,
-		ConnectDone:       traceWriter.ConnectDone,
-		TLSHandshakeStart: traceWriter.TLSHandshakeStart,
-		TLSHandshakeDone:  traceWriter.TLSHandshakeDone,
+	if id := RequestID(r.Context()); len(id) > 0 && len(r.Header.Get(HeaderRequestID)) == 0 {
+		// RoundTripper should not modify given request
+		r = r.Clone(r.Context())
+		r.Header.Set(HeaderRequestID, id)
 	}
+	config := loadConfig()
+	et := OutgoingRequestTracker(r, config)
+
+	traceWriter := newHttpTraceWriter(et)
+	traceWriter.pool = lookupTransportPool(w.RoundTripper)
+	trace := traceWriter.ClientTrace()
 	r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
 
 	defer func() {
 		if err != nil {
-			et.AddError(err)
+			traceWriter.update(func(et gomon.EventTracker) {
+				et.AddError(err)
+			})
+			traceWriter.Finish()
 		} else {
-			fillTrackerWithResponse(resp, et)
+			traceWriter.update(func(et gomon.EventTracker) {
+				fillTrackerWithResponse(resp, et)
+			})
+			// tracker is finished when body is closed
+			resp.Body = monitoredResponseBody(resp, config, traceWriter)
 		}
-		et.Finish()
 	}()
 	et.SetFingerprint("http-roundtripper")
 
@@ -116,13 +141,14 @@ func wrapTransportProxy(f fncProxy) fncProxy {
 	}
 
 	return func(r *http.Request) (u *url.URL, err error) {
-		et := OutgoingRequestTracker(r, defaultConfig)
+		et := OutgoingRequestTracker(r, loadConfig())
 		defer et.Finish()
 		et.SetFingerprint("http-trp-dialtls")
 		u, err = f(r)
 		if err != nil {
 			et.AddError(err)
-		} else {
+		} else if u != nil {
+			// nil means no proxy for this request
 			et.Set("proxy-path", u.Path)
 		}
 
@@ -212,53 +238,3 @@ func wrapTransportNextProto(f fncNextProto) fncNextProto {
 		return MonitoredRoundTripper(r)
 	}
 }
-
-func (h *httpTraceWriterEventTracker) GetConn(hostPort string) {
-	// go fmt.Println("getconn", time.Now())
-	h.EventTracker.Set("get-conn", time.Now())
-}
-
-func (h *httpTraceWriterEventTracker) GotFirstResponseByte() {
-	// go fmt.Println("gotf", time.Now())
-	h.EventTracker.Set("first-byte", time.Now())
-}
-
-func (h *httpTraceWriterEventTracker) DNSStart(httptrace.DNSStartInfo) {
-	// go fmt.Println("dnsstart", time.Now())
-	h.EventTracker.Set("dns-start", time.Now())
-}
-
-func (h *httpTraceWriterEventTracker) DNSDone(httptrace.DNSDoneInfo) {
-	// go fmt.Println("dnsdone", time.Now())
-	h.EventTracker.Set("dns-done", time.Now())
-}
-
-func (h *httpTraceWriterEventTracker) ConnectStart(network, addr string) {
-	// go fmt.Println("connstart", time.Now())
-	h.EventTracker.Set("conn-start", time.Now())
-}
-
-func (h *httpTraceWriterEventTracker) ConnectDone(network, addr string, err error) {
-	// go fmt.Println("conndone", time.Now())
-	h.EventTracker.Set("conn-done", time.Now())
-
-}
-
-func (h *httpTraceWriterEventTracker) TLSHandshakeStart() {
-	// go fmt.Println("tlshand", time.Now())
-	h.EventTracker.Set("tls-hand-start", time.Now())
-}
-
-func (h *httpTraceWriterEventTracker) TLSHandshakeDone(tls.ConnectionState, error) {
-	// go fmt.Println("tlsdone", time.Now())
-	h.EventTracker.Set("tls-hand-done", time.Now())
-
-}
-
-// unused so far
-// func (h *httpTraceWriterEventTracker) GotConn(httptrace.GotConnInfo) {}
-// func (h *httpTraceWriterEventTracker) PutIdleConn(err error) {}
-// func (h *httpTraceWriterEventTracker) Got100Continue() {}
-// func (h *httpTraceWriterEventTracker) WroteHeaders() {}
-// func (h *httpTraceWriterEventTracker) Wait100Continue() {}
-// func (h *httpTraceWriterEventTracker) WroteRequest(httptrace.WroteRequestInfo) {}
@@ -0,0 +1,273 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// httpTraceWriterEventTracker converts httptrace hooks into
+// phase durations of a single outgoing request.
+// hooks can be called from different goroutines (for example
+// ConnectStart/ConnectDone when dialing several addresses),
+// that's why every access goes through mu
+type httpTraceWriterEventTracker struct {
+	gomon.EventTracker
+
+	mu            sync.Mutex
+	start         time.Time
+	getConn       time.Time
+	gotConn       time.Time
+	dnsStart      time.Time
+	connectStart  map[string]time.Time
+	tlsStart      time.Time
+	wroteHeaders  time.Time
+	wroteRequest  time.Time
+	connectErrors []string
+	connectErr    error
+
+	// set when request is sent by monitored transport
+	pool     *transportPool
+	conn     *poolConn
+	released bool
+}
+
+var (
+	KeyTraceHostPort        = "host-port"
+	KeyTraceDNSTime         = "dns-time"
+	KeyTraceDNSAddrs        = "dns-addrs"
+	KeyTraceDNSCoalesced    = "dns-coalesced"
+	KeyTraceDNSError        = "dns-error"
+	KeyTraceConnectTime     = "connect-time"
+	KeyTraceConnectAddr     = "connect-addr"
+	KeyTraceConnectErrors   = "connect-errors"
+	KeyTraceTLSTime         = "tls-time"
+	KeyTraceTLSVersion      = "tls-version"
+	KeyTraceTLSCipher       = "tls-cipher"
+	KeyTraceTLSServerName   = "tls-server-name"
+	KeyTraceTLSProto        = "tls-proto"
+	KeyTraceTLSResumed      = "tls-resumed"
+	KeyTraceTLSError        = "tls-error"
+	KeyTraceConnWaitTime    = "conn-wait-time"
+	KeyTraceConnReused      = "conn-reused"
+	KeyTraceConnWasIdle     = "conn-was-idle"
+	KeyTraceConnIdleTime    = "conn-idle-time"
+	KeyTraceWriteTime       = "request-write-time"
+	KeyTraceWriteError      = "request-write-error"
+	KeyTraceContinueTime    = "continue-wait-time"
+	KeyTraceFirstByteTime   = "first-byte-time"
+	KeyTraceServerTime      = "server-processing-time"
+	KeyTracePutIdleConnFail = "put-idle-conn-error"
+)
+
+func newHttpTraceWriter(et gomon.EventTracker) *httpTraceWriterEventTracker {
+	return &httpTraceWriterEventTracker{
+		EventTracker: et,
+		start:        time.Now(),
+		connectStart: make(map[string]time.Time),
+	}
+}
+
+func (h *httpTraceWriterEventTracker) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn:              h.GetConn,
+		GotConn:              h.GotConn,
+		PutIdleConn:          h.PutIdleConn,
+		GotFirstResponseByte: h.GotFirstResponseByte,
+		Got100Continue:       h.Got100Continue,
+		DNSStart:             h.DNSStart,
+		DNSDone:              h.DNSDone,
+		ConnectStart:         h.ConnectStart,
+		ConnectDone:          h.ConnectDone,
+		TLSHandshakeStart:    h.TLSHandshakeStart,
+		TLSHandshakeDone:     h.TLSHandshakeDone,
+		WroteHeaders:         h.WroteHeaders,
+		WroteRequest:         h.WroteRequest,
+	}
+}
+
+func (h *httpTraceWriterEventTracker) GetConn(hostPort string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.getConn = time.Now()
+	h.Set(KeyTraceHostPort, hostPort)
+}
+
+func (h *httpTraceWriterEventTracker) GotConn(info httptrace.GotConnInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.gotConn = time.Now()
+	if !h.getConn.IsZero() {
+		wait := h.gotConn.Sub(h.getConn)
+		h.Set(KeyTraceConnWaitTime, wait)
+		if h.pool != nil {
+			h.conn = h.pool.gotConn(info, wait)
+		}
+	}
+	h.Set(KeyTraceConnReused, info.Reused)
+	h.Set(KeyTraceConnWasIdle, info.WasIdle)
+	if info.WasIdle {
+		h.Set(KeyTraceConnIdleTime, info.IdleTime)
+	}
+}
+
+func (h *httpTraceWriterEventTracker) PutIdleConn(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		// connection is closed, poolConn.Close takes care of pool
+		h.Set(KeyTracePutIdleConnFail, err.Error())
+		return
+	}
+
+	h.releaseConn()
+}
+
+// Finish releases connection in pool stats and adds the last dial
+// error if no connection was obtained, request is done when its
+// response body is closed or round trip failed
+func (h *httpTraceWriterEventTracker) Finish() {
+	h.mu.Lock()
+	h.releaseConn()
+	if h.gotConn.IsZero() && h.connectErr != nil {
+		h.AddError(h.connectErr)
+	}
+	h.mu.Unlock()
+	h.EventTracker.Finish()
+}
+
+// update calls f with h.mu held, writes to the tracker outside of
+// hooks (round tripper, response body) must go through it since
+// transport calls hooks from its own goroutines (e.g. PutIdleConn)
+func (h *httpTraceWriterEventTracker) update(f func(et gomon.EventTracker)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f(h.EventTracker)
+}
+
+// must be called with h.mu held
+func (h *httpTraceWriterEventTracker) releaseConn() {
+	if h.pool != nil && h.conn != nil && !h.released {
+		h.released = true
+		h.pool.release(h.conn)
+	}
+}
+
+func (h *httpTraceWriterEventTracker) DNSStart(httptrace.DNSStartInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dnsStart = time.Now()
+}
+
+func (h *httpTraceWriterEventTracker) DNSDone(info httptrace.DNSDoneInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dnsStart.IsZero() {
+		h.Set(KeyTraceDNSTime, time.Since(h.dnsStart))
+	}
+
+	addrs := make([]string, 0, len(info.Addrs))
+	for _, addr := range info.Addrs {
+		addrs = append(addrs, addr.String())
+	}
+	h.Set(KeyTraceDNSAddrs, addrs)
+	h.Set(KeyTraceDNSCoalesced, info.Coalesced)
+
+	if info.Err != nil {
+		h.Set(KeyTraceDNSError, info.Err.Error())
+		h.AddError(info.Err)
+	}
+}
+
+func (h *httpTraceWriterEventTracker) ConnectStart(network, addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connectStart[network+"/"+addr] = time.Now()
+}
+
+func (h *httpTraceWriterEventTracker) ConnectDone(network, addr string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start, ok := h.connectStart[network+"/"+addr]
+	if err != nil {
+		// with multiple addresses some of the attempts can fail
+		// while the request itself succeeds, keep all of them
+		h.connectErrors = append(h.connectErrors, addr+": "+err.Error())
+		h.Set(KeyTraceConnectErrors, h.connectErrors)
+		// error is added on Finish if no connection was obtained
+		h.connectErr = err
+		return
+	}
+
+	if ok {
+		h.Set(KeyTraceConnectTime, time.Since(start))
+	}
+	h.Set(KeyTraceConnectAddr, addr)
+}
+
+func (h *httpTraceWriterEventTracker) TLSHandshakeStart() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tlsStart = time.Now()
+}
+
+func (h *httpTraceWriterEventTracker) TLSHandshakeDone(state tls.ConnectionState, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.tlsStart.IsZero() {
+		h.Set(KeyTraceTLSTime, time.Since(h.tlsStart))
+	}
+
+	if err != nil {
+		h.Set(KeyTraceTLSError, err.Error())
+		h.AddError(err)
+		return
+	}
+
+	h.Set(KeyTraceTLSVersion, tls.VersionName(state.Version))
+	h.Set(KeyTraceTLSCipher, tls.CipherSuiteName(state.CipherSuite))
+	h.Set(KeyTraceTLSServerName, state.ServerName)
+	h.Set(KeyTraceTLSProto, state.NegotiatedProtocol)
+	h.Set(KeyTraceTLSResumed, state.DidResume)
+}
+
+func (h *httpTraceWriterEventTracker) WroteHeaders() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.wroteHeaders = time.Now()
+}
+
+func (h *httpTraceWriterEventTracker) Got100Continue() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.wroteHeaders.IsZero() {
+		h.Set(KeyTraceContinueTime, time.Since(h.wroteHeaders))
+	}
+}
+
+func (h *httpTraceWriterEventTracker) WroteRequest(info httptrace.WroteRequestInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.wroteRequest = time.Now()
+	if !h.gotConn.IsZero() {
+		h.Set(KeyTraceWriteTime, h.wroteRequest.Sub(h.gotConn))
+	}
+
+	if info.Err != nil {
+		h.Set(KeyTraceWriteError, info.Err.Error())
+		h.AddError(info.Err)
+	}
+}
+
+func (h *httpTraceWriterEventTracker) GotFirstResponseByte() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.Set(KeyTraceFirstByteTime, now.Sub(h.start))
+	if !h.wroteRequest.IsZero() {
+		h.Set(KeyTraceServerTime, now.Sub(h.wroteRequest))
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+
+	"github.com/iahmedov/gomon"
+)
+
+func TestClientTracePhases(t *testing.T) {
+	events.reset()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	c := &http.Client{Transport: MonitoredRoundTripper(transport)}
+	get(t, c, srv.URL)
+	get(t, c, srv.URL)
+
+	trips := events.waitMatch(t, "http-roundtripper", 2, to(srv.Listener.Addr().String()))
+	for _, key := range []string{
+		KeyTraceHostPort, KeyTraceConnWaitTime, KeyTraceConnReused, KeyTraceWriteTime,
+		KeyTraceFirstByteTime, KeyTraceServerTime,
+	} {
+		for _, et := range trips {
+			if et.Get(key) == nil {
+				t.Errorf("%s is not set", key)
+			}
+		}
+	}
+
+	reused := map[interface{}]int{}
+	for _, et := range trips {
+		reused[et.Get(KeyTraceConnReused)]++
+		if et.Get(KeyTraceConnReused) == false {
+			if et.Get(KeyTraceConnectTime) == nil || et.Get(KeyTraceConnectAddr) != srv.Listener.Addr().String() {
+				t.Errorf("connect phase of new connection: time %v, addr %v",
+					et.Get(KeyTraceConnectTime), et.Get(KeyTraceConnectAddr))
+			}
+		}
+	}
+	if reused[true] != 1 || reused[false] != 1 {
+		t.Errorf("reused connections = %v, want one new and one reused", reused)
+	}
+}
+
+func TestClientTraceConnectError(t *testing.T) {
+	events.reset()
+	// nothing listens on the port once listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := &http.Client{Transport: MonitoredRoundTripper(&http.Transport{})}
+	if _, err := c.Get("http://" + addr); err == nil {
+		t.Fatal("request to closed port succeeded")
+	}
+
+	et := events.waitMatch(t, "http-roundtripper", 1, to(addr))[0]
+	if errs, _ := et.Get(gomon.KeyErrors).([]error); len(errs) != 2 {
+		// dial error and error returned by round trip
+		t.Errorf("errors = %v, want dial and round trip errors", errs)
+	}
+	if connectErrors, _ := et.Get(KeyTraceConnectErrors).([]string); len(connectErrors) != 1 {
+		t.Errorf("connect errors = %v, want 1", connectErrors)
+	}
+}
+
+func TestClientTraceFailedAttemptWithConn(t *testing.T) {
+	et := gomon.FromContext(nil).NewChild(false)
+	h := newHttpTraceWriter(et)
+	trace := h.ClientTrace()
+
+	trace.GetConn("example.com:80")
+	trace.ConnectStart("tcp", "[::1]:80")
+	trace.ConnectStart("tcp", "127.0.0.1:80")
+	trace.ConnectDone("tcp", "[::1]:80", errors.New("connection refused"))
+	trace.ConnectDone("tcp", "127.0.0.1:80", nil)
+	trace.GotConn(httptrace.GotConnInfo{})
+	h.Finish()
+
+	// request itself succeeded with the other address
+	if errs := et.Get(gomon.KeyErrors); errs != nil {
+		t.Errorf("errors = %v, want none", errs)
+	}
+	if connectErrors, _ := et.Get(KeyTraceConnectErrors).([]string); len(connectErrors) != 1 ||
+		connectErrors[0] != "[::1]:80: connection refused" {
+		t.Errorf("connect errors = %v", connectErrors)
+	}
+	if addr := et.Get(KeyTraceConnectAddr); addr != "127.0.0.1:80" {
+		t.Errorf("connect addr = %v, want 127.0.0.1:80", addr)
+	}
+}
@@ -0,0 +1,59 @@
+package echo
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// recorder keeps events fed to gomon, listeners are fed asynchronously
+type recorder struct {
+	mu     sync.Mutex
+	events []gomon.EventTracker
+}
+
+var events = &recorder{}
+
+func TestMain(m *testing.M) {
+	gomon.RegisterListener(events)
+	gomon.Start()
+	os.Exit(m.Run())
+}
+
+func (r *recorder) Feed(et gomon.EventTracker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, et)
+}
+
+func (r *recorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+// wait returns events with fingerprint fp once there are n of them
+func (r *recorder) wait(t *testing.T, fp string, n int) []gomon.EventTracker {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var found []gomon.EventTracker
+		r.mu.Lock()
+		for _, et := range r.events {
+			if et.Get(gomon.KeyFingerprint) == fp {
+				found = append(found, et)
+			}
+		}
+		r.mu.Unlock()
+		if len(found) >= n {
+			return found
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d %q events, want %d", len(found), fp, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
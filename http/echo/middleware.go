@@ -0,0 +1,111 @@
+package echo
+
+import (
+	"sync/atomic"
+
+	"github.com/iahmedov/gomon"
+	gomonhttp "github.com/iahmedov/gomon/http"
+	"github.com/labstack/echo"
+)
+
+func init() {
+	gomon.SetConfigFunc(pluginName, SetConfig)
+}
+
+type PluginConfig struct {
+	gomonhttp.PluginConfig
+	// path params may contain user data, so they are opt-in
+	PathParams bool
+}
+
+var defaultConfig = &PluginConfig{
+	PluginConfig: gomonhttp.PluginConfig{
+		RequestHeaders:  true,
+		RespBody:        true,
+		RespBodyMaxSize: 1024,
+		RespHeaders:     true,
+		RespCode:        true,
+	},
+}
+
+// config set by SetConfig, loaded once per request
+var currentConfig atomic.Value // *PluginConfig
+
+var pluginName = "http-echo"
+
+var (
+	KeyPathParams   = "path-params"
+	KeyHandlerError = "handler-error"
+	// status code of echo.HTTPError returned by handler
+	KeyHandlerErrorCode = "handler-error-code"
+)
+
+func SetConfig(c gomon.TrackerConfig) {
+	if conf, ok := c.(*PluginConfig); ok {
+		currentConfig.Store(conf)
+	} else {
+		panic("not compatible config")
+	}
+}
+
+func loadConfig() *PluginConfig {
+	if conf, ok := currentConfig.Load().(*PluginConfig); ok {
+		return conf
+	}
+	return defaultConfig
+}
+
+func (p *PluginConfig) Name() string {
+	return pluginName
+}
+
+func Monitoring() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			conf := loadConfig()
+			config, monitor := conf.ForRequest(c.Request())
+			if !monitor {
+				return next(c)
+			}
+
+			resp := c.Response()
+			et, w, r := gomonhttp.StartIncomingRequest(resp.Writer, c.Request(), config)
+			et.SetFingerprint("echo-handle")
+			defer et.Finish()
+
+			original := resp.Writer
+			resp.Writer = w
+			c.SetRequest(r)
+			defer func() {
+				resp.Writer = original
+			}()
+
+			if err = next(c); err != nil {
+				et.AddError(err)
+				et.Set(KeyHandlerError, err.Error())
+				if he, ok := err.(*echo.HTTPError); ok {
+					et.Set(KeyHandlerErrorCode, he.Code)
+				}
+				// same as echo's own middlewares, error handler writes the
+				// response while it is still monitored, committed response
+				// is not written again when error reaches echo
+				c.Error(err)
+			}
+
+			if path := c.Path(); len(path) > 0 {
+				et.Set(gomonhttp.KeyRoute, path)
+			}
+			if names := c.ParamNames(); conf.PathParams && len(names) > 0 {
+				values := c.ParamValues()
+				params := make(map[string]string)
+				for i, k := range names {
+					if i < len(values) {
+						params[k] = values[i]
+					}
+				}
+				et.Set(KeyPathParams, params)
+			}
+			return
+		}
+	}
+}
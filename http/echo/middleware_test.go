@@ -0,0 +1,80 @@
+package echo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gomonhttp "github.com/iahmedov/gomon/http"
+	"github.com/labstack/echo"
+)
+
+func newServer() *httptest.Server {
+	e := echo.New()
+	e.Use(Monitoring())
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+	e.GET("/fail", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot, "no coffee")
+	})
+	return httptest.NewServer(e)
+}
+
+func get(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestMonitoringRoute(t *testing.T) {
+	defer SetConfig(defaultConfig)
+	srv := newServer()
+	defer srv.Close()
+
+	for _, pathParams := range []bool{false, true} {
+		events.reset()
+		SetConfig(&PluginConfig{
+			PluginConfig: gomonhttp.PluginConfig{RespCode: true},
+			PathParams:   pathParams,
+		})
+		get(t, srv.URL+"/users/7")
+
+		et := events.wait(t, "echo-handle", 1)[0]
+		if route := et.Get(gomonhttp.KeyRoute); route != "/users/:id" {
+			t.Errorf("route = %v, want /users/:id", route)
+		}
+		params, _ := et.Get(KeyPathParams).(map[string]string)
+		if pathParams && (len(params) != 1 || params["id"] != "7") {
+			t.Errorf("path params = %v, want id=7", params)
+		} else if !pathParams && params != nil {
+			t.Errorf("path params = %v, want none by default", params)
+		}
+	}
+}
+
+func TestMonitoringHandlerError(t *testing.T) {
+	events.reset()
+	srv := newServer()
+	defer srv.Close()
+
+	if code := get(t, srv.URL+"/fail"); code != http.StatusTeapot {
+		t.Errorf("status = %d, want 418", code)
+	}
+
+	et := events.wait(t, "echo-handle", 1)[0]
+	if code := et.Get(KeyHandlerErrorCode); code != http.StatusTeapot {
+		t.Errorf("handler error code = %v, want 418", code)
+	}
+	if et.Get(KeyHandlerError) == nil {
+		t.Error("handler error is not recorded")
+	}
+	// error handler writes response while it is monitored
+	if code := et.Get(gomonhttp.KeyResponseCode); code != http.StatusTeapot {
+		t.Errorf("response code = %v, want 418", code)
+	}
+}
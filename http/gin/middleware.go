@@ -1,6 +1,9 @@
 package gin
 
 import (
+	"net/http"
+	"sync/atomic"
+
 	"github.com/gin-gonic/gin"
 	"github.com/iahmedov/gomon"
 	gomonhttp "github.com/iahmedov/gomon/http"
@@ -10,33 +13,92 @@ func init() {
 	gomon.SetConfigFunc(pluginName, SetConfig)
 }
 
-var defaultConfig = &gomonhttp.PluginConfig{
-	RequestHeaders:  true,
-	RespBody:        true,
-	RespBodyMaxSize: 1024,
-	RespHeaders:     true,
-	RespCode:        true,
+// PluginConfig is the same as gomon/http.PluginConfig,
+// separate type lets gin be configured independently
+type PluginConfig struct {
+	gomonhttp.PluginConfig
+}
+
+// ginResponseWriter sends writes through monitored
+// ResponseWriter, rest is handled by gin
+type ginResponseWriter struct {
+	gin.ResponseWriter
+	monitored http.ResponseWriter
 }
 
+var defaultConfig = &PluginConfig{
+	gomonhttp.PluginConfig{
+		RequestHeaders:  true,
+		RespBody:        true,
+		RespBodyMaxSize: 1024,
+		RespHeaders:     true,
+		RespCode:        true,
+	},
+}
+
+// config set by SetConfig, loaded once per request
+var currentConfig atomic.Value // *PluginConfig
+
 var pluginName = "http-gin"
 
 func SetConfig(c gomon.TrackerConfig) {
-	if conf, ok := c.(*gomonhttp.PluginConfig); ok {
-		defaultConfig = conf
+	if conf, ok := c.(*PluginConfig); ok {
+		currentConfig.Store(conf)
 	} else {
 		panic("not compatible config")
 	}
 }
 
+func loadConfig() *PluginConfig {
+	if conf, ok := currentConfig.Load().(*PluginConfig); ok {
+		return conf
+	}
+	return defaultConfig
+}
+
 func (p *PluginConfig) Name() string {
 	return pluginName
 }
 
 func Monitoring() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		et := gomonhttp.IncomingRequestTracker(c.Writer, c.Request, defaultConfig)
+		config, monitor := loadConfig().ForRequest(c.Request)
+		if !monitor {
+			c.Next()
+			return
+		}
+
+		et, w, r := gomonhttp.StartIncomingRequest(c.Writer, c.Request, config)
 		et.SetFingerprint("gin-handle")
 		defer et.Finish()
+
+		c.Writer = &ginResponseWriter{c.Writer, w}
+		c.Request = r
 		c.Next()
 	}
 }
+
+func (g *ginResponseWriter) Write(p []byte) (int, error) {
+	return g.monitored.Write(p)
+}
+
+func (g *ginResponseWriter) WriteString(s string) (int, error) {
+	return g.monitored.Write([]byte(s))
+}
+
+func (g *ginResponseWriter) WriteHeader(code int) {
+	g.monitored.WriteHeader(code)
+}
+
+func (g *ginResponseWriter) WriteHeaderNow() {
+	if !g.Written() {
+		g.monitored.WriteHeader(g.Status())
+	}
+	g.ResponseWriter.WriteHeaderNow()
+}
+
+func (g *ginResponseWriter) Flush() {
+	if flusher, ok := g.monitored.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
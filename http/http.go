@@ -3,11 +3,16 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/iahmedov/gomon"
 	gomonnet "github.com/iahmedov/gomon/net"
@@ -27,6 +32,34 @@ type PluginConfig struct {
 	RespBodyMaxSize int
 	RespHeaders     bool
 	RespCode        bool
+	// adds Server-Timing header with total time and
+	// time spent in db/outgoing http calls of the request
+	ServerTiming bool
+	// reads X-Request-ID of incoming request or generates new one,
+	// sends it back in response and with outgoing requests
+	RequestID bool
+
+	// fraction of incoming requests to monitor, 0 means all
+	SampleRate float64
+	// per route overrides, see Rule
+	Rules []Rule
+	// interval of progress events of streaming responses
+	// (server-sent events, repeatedly flushed), zero disables them
+	StreamProgressInterval time.Duration
+	// keeps in-flight gauges per route (see InFlight), route of a
+	// request is then matched before serving it and ServeMux matches
+	// it once more. Otherwise only total is kept and route is taken
+	// from Request.Pattern set by ServeMux while serving
+	InFlightRoutes bool
+
+	// client
+	// reports response bodies which were garbage collected
+	// without being closed, relies on runtime.SetFinalizer
+	RespBodyLeakDetection bool
+	// interval of connection pool reports of monitored
+	// transports, zero disables reports. Transports created
+	// while it is set must be released with StopTransportStats
+	PoolStatInterval time.Duration
 }
 
 type wrappedMux struct {
@@ -34,6 +67,7 @@ type wrappedMux struct {
 
 	config   *PluginConfig
 	listener gomon.Listener
+	inFlight *inFlightGauge
 }
 
 type wrappedResponseWriter struct {
@@ -46,6 +80,26 @@ type wrappedResponseWriter struct {
 	body         *bytes.Buffer
 	config       *PluginConfig
 	responseCode int
+	wroteHeader  bool
+	timings      *gomon.Timings
+	websocket    bool
+
+	// response size/timing, mostly interesting for streaming responses
+	start     time.Time
+	ctx       context.Context
+	streamMu  sync.Mutex
+	stream    *responseStream
+	written   int64
+	firstByte time.Duration
+	flushes   int64
+	lastFlush time.Time
+}
+
+// incomingTracker completes tracker with response
+// information collected by wrappedResponseWriter
+type incomingTracker struct {
+	httpEventTracker
+	w *wrappedResponseWriter
 }
 
 var defaultConfig = &PluginConfig{
@@ -56,9 +110,13 @@ var defaultConfig = &PluginConfig{
 	RespCode:        true,
 }
 
+// config set by SetConfig, it can be replaced while
+// requests are served, so it is loaded once per request
+var currentConfig atomic.Value // *PluginConfig
+
 var defaultMux = &wrappedMux{
-	handler: nil,
-	config:  defaultConfig,
+	handler:  nil,
+	inFlight: newInFlightGauge(),
 }
 
 var (
@@ -81,12 +139,21 @@ const (
 
 func SetConfig(conf gomon.TrackerConfig) {
 	if c, ok := conf.(*PluginConfig); ok {
-		defaultConfig = c
+		currentConfig.Store(c)
 	} else {
 		panic("setting not compatible config")
 	}
 }
 
+// loadConfig returns config set by SetConfig, config
+// must not be modified after it is set
+func loadConfig() *PluginConfig {
+	if c, ok := currentConfig.Load().(*PluginConfig); ok {
+		return c
+	}
+	return defaultConfig
+}
+
 func min(a, b int) int {
 	if a > b {
 		return b
@@ -102,7 +169,7 @@ func (p *PluginConfig) Name() string {
 func requestTracker(r *http.Request, config *PluginConfig) httpEventTracker {
 	// TODO:
 	// NOTE: what if use httputil.DumpRequest ?
-	tracker := &httpEventTrackerImpl{gomon.FromContext(nil).NewChild(false)}
+	tracker := &httpEventTrackerImpl{gomon.FromContext(r.Context()).NewChild(false)}
 
 	tracker.SetDirection(kHttpDirectionIncoming)
 	tracker.SetMethod(r.Method)
@@ -135,10 +202,6 @@ func IncomingRequestTracker(w http.ResponseWriter, r *http.Request, config *Plug
 	return tracker
 }
 
-func (p *wrappedMux) incomingRequestTracker(w http.ResponseWriter, r *http.Request) httpEventTracker {
-	return IncomingRequestTracker(w, r, p.config)
-}
-
 func (p *wrappedMux) Name() string {
 	return pluginName
 }
@@ -151,17 +214,60 @@ func (p *wrappedMux) HandleTracker(et gomon.EventTracker) {
 	p.listener.Feed(et)
 }
 
-func (p *wrappedMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// StartIncomingRequest creates tracker for incoming request, returned
+// ResponseWriter and Request should be passed to the next handler, request
+// context carries the tracker so that downstream calls become its children
+func StartIncomingRequest(w http.ResponseWriter, r *http.Request, config *PluginConfig) (httpEventTracker, http.ResponseWriter, *http.Request) {
+	tracker := IncomingRequestTracker(w, r, config)
+	setWaitTimes(tracker, r, time.Now())
+	ctx := gomon.WithContext(r.Context(), tracker)
+
+	wr := newWrappedResponseWriter(w, config, tracker)
+	wr.websocket = isWebSocketUpgrade(r)
+	wr.ctx = r.Context()
+	if config.RequestID {
+		id := incomingRequestID(r)
+		tracker.Set(KeyRequestID, id)
+		w.Header().Set(HeaderRequestID, id)
+		ctx = WithRequestID(ctx, id)
+	}
 
-	tracker := p.incomingRequestTracker(w, r)
+	if config.ServerTiming {
+		wr.timings = gomon.NewTimings()
+		ctx = gomon.WithTimings(ctx, wr.timings)
+	}
 
-	w = monitoredResponseWriter(w, p.config, tracker)
+	return &incomingTracker{tracker, wr}, wr, r.WithContext(ctx)
+}
+
+func (t *incomingTracker) Finish() {
+	t.w.finish()
+	t.httpEventTracker.Finish()
+}
+
+func (p *wrappedMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	config, monitor := p.pluginConfig().ForRequest(r)
+	if !monitor {
+		p.handler.ServeHTTP(w, r)
+		return
+	}
+
+	tracker, w, r := StartIncomingRequest(w, r, config)
 	tracker.SetFingerprint("http-wmux-servehttp")
 	defer tracker.Finish()
+	defer p.trackInFlight(tracker, r, config)()
 
 	p.handler.ServeHTTP(w, r)
 }
 
+// muxes without own config follow SetConfig
+func (p *wrappedMux) pluginConfig() *PluginConfig {
+	if p.config == nil {
+		return loadConfig()
+	}
+	return p.config
+}
+
 func (p *wrappedMux) MonitoringHandler(handler http.Handler) http.Handler {
 	if handler == nil {
 		p.handler = http.DefaultServeMux
@@ -173,18 +279,26 @@ func (p *wrappedMux) MonitoringHandler(handler http.Handler) http.Handler {
 
 func (p *wrappedMux) MonitoringWrapper(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		config, monitor := p.pluginConfig().ForRequest(r)
+		if !monitor {
+			handler(w, r)
+			return
+		}
 
-		tracker := p.incomingRequestTracker(w, r)
-
-		w = monitoredResponseWriter(w, p.config, tracker)
+		tracker, w, r := StartIncomingRequest(w, r, config)
 		tracker.SetFingerprint("http-wmux-handler")
 		defer tracker.Finish()
+		defer p.trackInFlight(tracker, r, config)()
 
 		handler(w, r)
 	}
 }
 
 func monitoredResponseWriter(w http.ResponseWriter, config *PluginConfig, et gomon.EventTracker) http.ResponseWriter {
+	return newWrappedResponseWriter(w, config, et)
+}
+
+func newWrappedResponseWriter(w http.ResponseWriter, config *PluginConfig, et gomon.EventTracker) *wrappedResponseWriter {
 	_, flusher := w.(http.Flusher)
 	_, notifier := w.(http.CloseNotifier)
 	_, hijacker := w.(http.Hijacker)
@@ -199,6 +313,8 @@ func monitoredResponseWriter(w http.ResponseWriter, config *PluginConfig, et gom
 		body:           bytes.NewBuffer(nil),
 		config:         config,
 		responseCode:   kResponseCodeUnknown,
+		start:          time.Now(),
+		ctx:            context.Background(),
 	}
 	if wr.config.RespBody {
 		et.Set(KeyResponseBody, wr.body)
@@ -216,7 +332,7 @@ func (r *wrappedResponseWriter) Write(p []byte) (n int, err error) {
 		}
 	}()
 
-	if r.config.RespBody {
+	if r.config.RespBody && !r.streaming() {
 		diff := r.config.RespBodyMaxSize - r.body.Len()
 		_ = diff
 		if diff > 0 {
@@ -232,7 +348,9 @@ func (r *wrappedResponseWriter) Write(p []byte) (n int, err error) {
 			r.responseCode = kResponseCodeDoNotSet
 		}
 	}
+	r.beforeWriteHeader()
 	n, err = r.ResponseWriter.Write(p)
+	r.wrote(n)
 	return
 }
 
@@ -242,15 +360,34 @@ func (r *wrappedResponseWriter) WriteHeader(code int) {
 		r.tracker.Set(KeyResponseCode, code)
 	}
 
+	r.beforeWriteHeader()
 	r.ResponseWriter.WriteHeader(code)
 }
 
+// beforeWriteHeader is the last chance to modify response headers
+func (r *wrappedResponseWriter) beforeWriteHeader() {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+
+	if r.timings != nil {
+		r.Header().Set(HeaderServerTiming, serverTimingHeader(r.timings))
+	}
+
+	if strings.HasPrefix(r.Header().Get("Content-Type"), "text/event-stream") {
+		r.startStream()
+	}
+}
+
 func (r *wrappedResponseWriter) Flush() {
 	flusher, ok := r.ResponseWriter.(http.Flusher)
 	if !ok {
 		return
 	}
+	r.beforeWriteHeader()
 	flusher.Flush()
+	r.flushed()
 	return
 }
 
@@ -270,9 +407,18 @@ func (r *wrappedResponseWriter) Hijack() (c net.Conn, b *bufio.ReadWriter, err e
 	}
 	r.tracker.Set("hijack", true)
 	c, b, err = hijacker.Hijack()
-	if c != nil {
-		c = gomonnet.MonitoredConn(c, nil)
+	if c == nil {
+		return
+	}
+
+	// connection outlives the request, its trackers are children of request tracker
+	c = gomonnet.MonitoredConn(c, gomon.WithContext(context.Background(), r.tracker))
+	var ws *wsConn
+	if r.websocket {
+		ws = newWebSocketConn(c, r.tracker)
+		c = ws
 	}
+	b = hijackedReadWriter(b, c, ws)
 	return
 }
 
@@ -280,6 +426,20 @@ func MonitoringHandler(handler http.Handler) http.Handler {
 	return defaultMux.MonitoringHandler(handler)
 }
 
+// NewMonitoringHandler is same as MonitoringHandler, but returned handler
+// is not shared, so it can be called for several handlers (e.g. servers)
+func NewMonitoringHandler(handler http.Handler) http.Handler {
+	p := &wrappedMux{inFlight: newInFlightGauge()}
+	return p.MonitoringHandler(handler)
+}
+
 func MonitoringWrapper(handler http.HandlerFunc) http.HandlerFunc {
 	return defaultMux.MonitoringWrapper(handler)
 }
+
+// IsMonitoringHandler reports whether h is returned by MonitoringHandler
+// or NewMonitoringHandler, wrapping it again would track requests twice
+func IsMonitoringHandler(h http.Handler) bool {
+	_, ok := h.(*wrappedMux)
+	return ok
+}
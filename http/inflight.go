@@ -0,0 +1,179 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gomonnet "github.com/iahmedov/gomon/net"
+)
+
+// inFlightGauge counts requests which are currently
+// being handled, grouped by route
+type inFlightGauge struct {
+	mu     sync.Mutex
+	total  int64
+	routes map[string]int64
+}
+
+var (
+	HeaderRequestStart = "X-Request-Start"
+	HeaderQueueStart   = "X-Queue-Start"
+
+	KeyRoute         = "route"
+	KeyInFlight      = "in-flight"
+	KeyInFlightTotal = "in-flight-total"
+	KeyQueueTime     = "queue-time"
+	KeyAcceptTime    = "accept-time"
+	KeyConnRequests  = "conn-requests"
+)
+
+func newInFlightGauge() *inFlightGauge {
+	return &inFlightGauge{
+		routes: make(map[string]int64),
+	}
+}
+
+// start returns number of in-flight requests of the route and
+// overall, including the one being started. Empty route is
+// counted only in total
+func (g *inFlightGauge) start(route string) (inRoute, total int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.total++
+	if len(route) == 0 {
+		return 0, g.total
+	}
+	g.routes[route]++
+	return g.routes[route], g.total
+}
+
+func (g *inFlightGauge) done(route string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.total--
+	if len(route) == 0 {
+		return
+	}
+	g.routes[route]--
+	if g.routes[route] <= 0 {
+		delete(g.routes, route)
+	}
+}
+
+func (g *inFlightGauge) snapshot() map[string]int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := make(map[string]int64, len(g.routes))
+	for route, n := range g.routes {
+		s[route] = n
+	}
+	return s
+}
+
+// route returns registered pattern when handler is http.ServeMux,
+// otherwise path of the request
+// implemented by http.ServeMux and compatible muxes
+type patternMatcher interface {
+	Handler(r *http.Request) (h http.Handler, pattern string)
+}
+
+func (p *wrappedMux) route(r *http.Request) string {
+	if mux, ok := p.handler.(patternMatcher); ok {
+		if _, pattern := mux.Handler(r); len(pattern) > 0 {
+			return pattern
+		}
+	}
+	return r.URL.Path
+}
+
+// servedRoute returns pattern set by http.ServeMux while serving r,
+// otherwise path of the request
+func servedRoute(r *http.Request) string {
+	if len(r.Pattern) > 0 {
+		return r.Pattern
+	}
+	return r.URL.Path
+}
+
+// trackInFlight should be deferred with returned function
+func (p *wrappedMux) trackInFlight(tracker httpEventTracker, r *http.Request, config *PluginConfig) func() {
+	if !config.InFlightRoutes {
+		_, total := p.inFlight.start("")
+		tracker.Set(KeyInFlightTotal, total)
+		return func() {
+			p.inFlight.done("")
+			tracker.Set(KeyRoute, servedRoute(r))
+		}
+	}
+
+	route := p.route(r)
+	inRoute, total := p.inFlight.start(route)
+	tracker.Set(KeyRoute, route)
+	tracker.Set(KeyInFlight, inRoute)
+	tracker.Set(KeyInFlightTotal, total)
+	return func() {
+		p.inFlight.done(route)
+	}
+}
+
+// InFlight returns number of requests being handled per route,
+// it is empty unless PluginConfig.InFlightRoutes is set
+func (p *wrappedMux) InFlight() map[string]int64 {
+	return p.inFlight.snapshot()
+}
+
+func InFlight() map[string]int64 {
+	return defaultMux.InFlight()
+}
+
+// setWaitTimes sets time spent in load balancer queue and time
+// between connection accept and start of the first request on it
+func setWaitTimes(tracker httpEventTracker, r *http.Request, now time.Time) {
+	for _, h := range []string{HeaderRequestStart, HeaderQueueStart} {
+		if start, ok := parseRequestStart(r.Header.Get(h)); ok {
+			queue := now.Sub(start)
+			if queue < 0 {
+				// clocks of load balancer and this host differ
+				queue = 0
+			}
+			tracker.Set(KeyQueueTime, queue)
+			break
+		}
+	}
+
+	if nth, ok := gomonnet.StartConnRequest(r.Context()); ok {
+		tracker.Set(KeyConnRequests, nth)
+		if nth == 1 {
+			sinceAccept, _, _ := gomonnet.ConnAge(r.Context())
+			tracker.Set(KeyAcceptTime, sinceAccept)
+		}
+	}
+}
+
+// parseRequestStart parses `t=<unix time>` written by load balancers,
+// unit of the time (s, ms, us, ns) is guessed from its magnitude
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	if len(v) == 0 {
+		return time.Time{}, false
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return time.Time{}, false
+	}
+
+	switch {
+	case f > 1e18: // nanoseconds
+		return time.Unix(0, int64(f)), true
+	case f > 1e15: // microseconds
+		return time.Unix(0, int64(f)*int64(time.Microsecond)), true
+	case f > 1e12: // milliseconds
+		return time.Unix(0, int64(f)*int64(time.Millisecond)), true
+	default: // seconds, possibly with fraction
+		return time.Unix(0, int64(f*float64(time.Second))), true
+	}
+}
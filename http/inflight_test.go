@@ -0,0 +1,83 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRequestStart(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 30, 15, 250000000, time.UTC)
+	cases := []struct {
+		header string
+		want   time.Time
+	}{
+		{"t=1714566615", want.Truncate(time.Second)},
+		{"t=1714566615.25", want},
+		{"1714566615250", want},
+		{"t=1714566615250000", want},
+		{"t=1714566615250000000", want},
+		{" t=1714566615 ", want.Truncate(time.Second)},
+	}
+	for _, c := range cases {
+		got, ok := parseRequestStart(c.header)
+		if !ok {
+			t.Errorf("parseRequestStart(%q) failed", c.header)
+			continue
+		}
+		// float seconds lose precision below microseconds
+		if d := got.Sub(c.want); d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("parseRequestStart(%q) = %s, want %s", c.header, got.UTC(), c.want)
+		}
+	}
+
+	for _, header := range []string{"", "t=", "t=abc", "t=-5", "0"} {
+		if got, ok := parseRequestStart(header); ok {
+			t.Errorf("parseRequestStart(%q) = %s, want failure", header, got)
+		}
+	}
+}
+
+func TestInFlightGauge(t *testing.T) {
+	g := newInFlightGauge()
+	g.start("/a")
+	if inRoute, total := g.start("/a"); inRoute != 2 || total != 2 {
+		t.Errorf("start = %d, %d, want 2, 2", inRoute, total)
+	}
+	if inRoute, total := g.start("/b"); inRoute != 1 || total != 3 {
+		t.Errorf("start = %d, %d, want 1, 3", inRoute, total)
+	}
+
+	g.done("/a")
+	g.done("/b")
+	snapshot := g.snapshot()
+	if len(snapshot) != 1 || snapshot["/a"] != 1 {
+		t.Errorf("snapshot = %v, want map[/a:1]", snapshot)
+	}
+}
+
+func TestRouteOfServedRequest(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewMonitoringHandler(mux).(*wrappedMux)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	for _, inFlightRoutes := range []bool{false, true} {
+		events.reset()
+		handler.config = &PluginConfig{InFlightRoutes: inFlightRoutes}
+		get(t, srv.Client(), srv.URL+"/users/5")
+
+		et := events.wait(t, "http-wmux-servehttp", 1)[0]
+		if route := et.Get(KeyRoute); route != "/users/{id}" {
+			t.Errorf("InFlightRoutes=%v: route = %v, want /users/{id}", inFlightRoutes, route)
+		}
+		if total := et.Get(KeyInFlightTotal); total != int64(1) {
+			t.Errorf("InFlightRoutes=%v: in-flight total = %v, want 1", inFlightRoutes, total)
+		}
+		if inRoute, want := et.Get(KeyInFlight), map[bool]interface{}{true: int64(1)}[inFlightRoutes]; inRoute != want {
+			t.Errorf("InFlightRoutes=%v: in-flight = %v, want %v", inFlightRoutes, inRoute, want)
+		}
+	}
+}
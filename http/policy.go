@@ -0,0 +1,86 @@
+package http
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+)
+
+// Rule changes how matching requests are monitored,
+// rules are checked in order and the first matching one is used
+type Rule struct {
+	// empty Method/Host/Path matches anything
+	Method string
+	Host   string
+	// exact path, or path prefix when ends with "*"
+	Path string
+
+	// request is not monitored at all
+	Ignore bool
+	// fraction of matching requests to monitor, 0 keeps PluginConfig.SampleRate
+	SampleRate float64
+	// replaces capture settings (headers, body, ...) for matching
+	// requests, nil keeps current config. Rules of Config are not used
+	Config *PluginConfig
+}
+
+func (rule *Rule) match(r *http.Request) bool {
+	if len(rule.Method) > 0 && !strings.EqualFold(rule.Method, r.Method) {
+		return false
+	}
+
+	if len(rule.Host) > 0 && !strings.EqualFold(rule.Host, requestHost(r)) {
+		return false
+	}
+
+	if len(rule.Path) > 0 {
+		if strings.HasSuffix(rule.Path, "*") {
+			return strings.HasPrefix(r.URL.Path, strings.TrimSuffix(rule.Path, "*"))
+		}
+		return rule.Path == r.URL.Path
+	}
+
+	return true
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if len(host) == 0 {
+		host = r.URL.Host
+	}
+	// strip port
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		host = host[:i]
+	}
+	return host
+}
+
+// ForRequest returns config which should be used for monitoring r,
+// monitor is false when request is ignored or not sampled
+func (p *PluginConfig) ForRequest(r *http.Request) (conf *PluginConfig, monitor bool) {
+	conf = p
+	rate := p.SampleRate
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.match(r) {
+			continue
+		}
+
+		if rule.Ignore {
+			return nil, false
+		}
+		if rule.SampleRate > 0 {
+			rate = rule.SampleRate
+		}
+		if rule.Config != nil {
+			conf = rule.Config
+		}
+		break
+	}
+
+	if rate > 0 && rate < 1 && rand.Float64() >= rate {
+		return nil, false
+	}
+
+	return conf, true
+}
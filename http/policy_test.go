@@ -0,0 +1,73 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRuleMatch(t *testing.T) {
+	cases := []struct {
+		rule   Rule
+		method string
+		target string
+		match  bool
+	}{
+		{Rule{}, "GET", "http://example.com/a", true},
+		{Rule{Method: "post"}, "POST", "http://example.com/a", true},
+		{Rule{Method: "POST"}, "GET", "http://example.com/a", false},
+		{Rule{Host: "Example.com"}, "GET", "http://example.com:8080/a", true},
+		{Rule{Host: "example.com"}, "GET", "http://api.example.com/a", false},
+		{Rule{Host: "::1"}, "GET", "http://[::1]:8080/a", false},
+		{Rule{Host: "[::1]"}, "GET", "http://[::1]:8080/a", true},
+		{Rule{Path: "/health"}, "GET", "http://example.com/health", true},
+		{Rule{Path: "/health"}, "GET", "http://example.com/health/db", false},
+		{Rule{Path: "/static/*"}, "GET", "http://example.com/static/app.js", true},
+		{Rule{Path: "/static/*"}, "GET", "http://example.com/static", false},
+		{Rule{Method: "GET", Path: "/api/*"}, "DELETE", "http://example.com/api/x", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.target, nil)
+		if got := c.rule.match(r); got != c.match {
+			t.Errorf("%+v match %s %s = %v, want %v", c.rule, c.method, c.target, got, c.match)
+		}
+	}
+}
+
+func TestForRequest(t *testing.T) {
+	health := &PluginConfig{RespCode: true}
+	config := &PluginConfig{
+		Rules: []Rule{
+			{Path: "/metrics", Ignore: true},
+			{Path: "/health", Config: health},
+			{Path: "/health", Ignore: true},
+			{Path: "/sampled/*", SampleRate: 0.000001},
+		},
+	}
+
+	cases := []struct {
+		path    string
+		conf    *PluginConfig
+		monitor bool
+	}{
+		{"/metrics", nil, false},
+		// the first matching rule is used
+		{"/health", health, true},
+		{"/other", config, true},
+	}
+	for _, c := range cases {
+		conf, monitor := config.ForRequest(httptest.NewRequest("GET", c.path, nil))
+		if conf != c.conf || monitor != c.monitor {
+			t.Errorf("ForRequest(%s) = %p, %v, want %p, %v", c.path, conf, monitor, c.conf, c.monitor)
+		}
+	}
+
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if _, monitor := config.ForRequest(httptest.NewRequest("GET", "/sampled/x", nil)); monitor {
+			sampled++
+		}
+	}
+	if sampled > 10 {
+		t.Errorf("%d of 1000 requests sampled with rate 0.000001", sampled)
+	}
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// transportPool collects connection pool statistics of a single
+// monitored http.Transport. Dialing and open connections are known
+// from wrapped dial functions, active/idle state and wait time
+// come from httptrace hooks of wrappedRoundTripper
+type transportPool struct {
+	name string
+
+	mu           sync.Mutex
+	hosts        map[string]*hostPoolStat
+	gotConns     int64
+	reusedConns  int64
+	dialFailures int64
+	waitTime     *gomon.Histogram
+
+	stop chan struct{}
+}
+
+type hostPoolStat struct {
+	conns        map[*poolConn]struct{}
+	dialing      int
+	dialFailures int64
+}
+
+type poolConn struct {
+	net.Conn
+	pool *transportPool
+	host string
+
+	// guarded by pool.mu, requests using connection:
+	// at most one for http/1, any number of streams for http/2
+	streams int
+	closed  bool
+}
+
+var transportPools sync.Map // *http.Transport -> *transportPool
+
+var (
+	KeyPoolName         = "name"
+	KeyPoolHosts        = "hosts"
+	KeyPoolGotConns     = "got-conns"
+	KeyPoolReusedConns  = "reused-conns"
+	KeyPoolReuseRatio   = "reuse-ratio"
+	KeyPoolDialFailures = "dial-failures"
+	KeyPoolWaitTime     = "conn-wait-time"
+)
+
+func newTransportPool(name string) *transportPool {
+	return &transportPool{
+		name:     name,
+		hosts:    make(map[string]*hostPoolStat),
+		waitTime: gomon.NewHistogram(nil),
+		stop:     make(chan struct{}),
+	}
+}
+
+func lookupTransportPool(rt http.RoundTripper) *transportPool {
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	if pool, ok := transportPools.Load(t); ok {
+		return pool.(*transportPool)
+	}
+	return nil
+}
+
+// StopTransportStats stops periodic pool reports of transport
+// returned by MonitoredTransport/MonitoredNamedTransport, it should
+// be called once transport is not used if PoolStatInterval is set,
+// otherwise transport and its reporting goroutine are never released
+func StopTransportStats(t *http.Transport) {
+	if pool, ok := transportPools.Load(t); ok {
+		transportPools.Delete(t)
+		close(pool.(*transportPool).stop)
+	}
+}
+
+func (p *transportPool) host(addr string) *hostPoolStat {
+	h, ok := p.hosts[addr]
+	if !ok {
+		h = &hostPoolStat{conns: make(map[*poolConn]struct{})}
+		p.hosts[addr] = h
+	}
+	return h
+}
+
+func (p *transportPool) dialStart(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.host(addr).dialing++
+}
+
+func (p *transportPool) dialDone(addr string, c net.Conn, err error) net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.host(addr)
+	h.dialing--
+	if err != nil || c == nil {
+		h.dialFailures++
+		p.dialFailures++
+		return c
+	}
+
+	pc := &poolConn{Conn: c, pool: p, host: addr}
+	h.conns[pc] = struct{}{}
+	return pc
+}
+
+func (p *transportPool) gotConn(info httptrace.GotConnInfo, wait time.Duration) *poolConn {
+	p.waitTime.Observe(wait)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gotConns++
+	if info.Reused {
+		p.reusedConns++
+	}
+
+	pc := unwrapPoolConn(info.Conn)
+	if pc != nil {
+		pc.streams++
+	}
+	return pc
+}
+
+// release is called once per gotConn, when http/1 connection is
+// put back to idle pool or request is finished (http/2 never calls
+// PutIdleConn, its connections are shared by concurrent requests)
+func (p *transportPool) release(pc *poolConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc.streams > 0 {
+		pc.streams--
+	}
+}
+
+func (p *transportPool) closed(pc *poolConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc.closed {
+		return
+	}
+	pc.closed = true
+	delete(p.host(pc.host).conns, pc)
+}
+
+func (p *transportPool) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if gomon.Started() {
+				p.report()
+			}
+		}
+	}
+}
+
+// report sends current gauges (active, idle, dialing) and
+// counters collected since previous report
+func (p *transportPool) report() {
+	et := gomon.FromContext(nil).NewChild(false)
+	et.SetFingerprint("http-transport-pool")
+	defer et.Finish()
+
+	p.mu.Lock()
+	hosts := make(map[string]interface{}, len(p.hosts))
+	for addr, h := range p.hosts {
+		active := 0
+		for pc := range h.conns {
+			if pc.streams > 0 {
+				active++
+			}
+		}
+		hosts[addr] = map[string]interface{}{
+			"active":        active,
+			"idle":          len(h.conns) - active,
+			"dialing":       h.dialing,
+			"dial-failures": h.dialFailures,
+		}
+		h.dialFailures = 0
+
+		if len(h.conns) == 0 && h.dialing == 0 {
+			delete(p.hosts, addr)
+		}
+	}
+
+	et.Set(KeyPoolName, p.name)
+	et.Set(KeyPoolHosts, hosts)
+	et.Set(KeyPoolGotConns, p.gotConns)
+	et.Set(KeyPoolReusedConns, p.reusedConns)
+	if p.gotConns > 0 {
+		et.Set(KeyPoolReuseRatio, float64(p.reusedConns)/float64(p.gotConns))
+	}
+	et.Set(KeyPoolDialFailures, p.dialFailures)
+	p.gotConns, p.reusedConns, p.dialFailures = 0, 0, 0
+	p.mu.Unlock()
+
+	et.Set(KeyPoolWaitTime, p.waitTime.KVData())
+	p.waitTime.Reset()
+}
+
+func (p *transportPool) wrapDialContext(f fncDialContext) fncDialContext {
+	// nil pool when pool stats are off
+	if f == nil || p == nil {
+		return f
+	}
+
+	return func(ctx context.Context, network, addr string) (c net.Conn, err error) {
+		p.dialStart(addr)
+		c, err = f(ctx, network, addr)
+		return p.dialDone(addr, c, err), err
+	}
+}
+
+func (p *transportPool) wrapDial(f fncDial) fncDial {
+	// nil pool when pool stats are off
+	if f == nil || p == nil {
+		return f
+	}
+
+	return func(network, addr string) (c net.Conn, err error) {
+		p.dialStart(addr)
+		c, err = f(network, addr)
+		return p.dialDone(addr, c, err), err
+	}
+}
+
+func (p *transportPool) wrapDialTLS(f fncDialTLS) fncDialTLS {
+	// nil pool when pool stats are off
+	if f == nil || p == nil {
+		return f
+	}
+
+	return func(network, addr string) (c net.Conn, err error) {
+		p.dialStart(addr)
+		c, err = f(network, addr)
+		return p.dialDone(addr, c, err), err
+	}
+}
+
+func (c *poolConn) Close() (err error) {
+	err = c.Conn.Close()
+	c.pool.closed(c)
+	return
+}
+
+// unwrapPoolConn finds poolConn under connection given to
+// httptrace.GotConn, https connections are wrapped by *tls.Conn
+func unwrapPoolConn(c net.Conn) *poolConn {
+	for c != nil {
+		switch v := c.(type) {
+		case *poolConn:
+			return v
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
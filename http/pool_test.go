@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countingDialer(dials *int32) fncDialContext {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+}
+
+func get(t *testing.T, c *http.Client, url string) {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func TestMonitoredTransportKeepsDialer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var dials int32
+	transport := MonitoredTransport(&http.Transport{DialContext: countingDialer(&dials)})
+	defer transport.CloseIdleConnections()
+	if transport.DialContext == nil {
+		t.Fatal("DialContext is dropped")
+	}
+	if lookupTransportPool(transport) != nil {
+		t.Error("pool stats are collected with default config")
+	}
+
+	get(t, &http.Client{Transport: MonitoredRoundTripper(transport)}, srv.URL)
+	if atomic.LoadInt32(&dials) != 1 {
+		t.Errorf("user dialer is called %d times, want 1", dials)
+	}
+}
+
+func TestTransportPoolStats(t *testing.T) {
+	SetConfig(&PluginConfig{PoolStatInterval: time.Hour})
+	defer SetConfig(defaultConfig)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var dials int32
+	transport := MonitoredTransport(&http.Transport{DialContext: countingDialer(&dials)})
+	defer StopTransportStats(transport)
+	defer transport.CloseIdleConnections()
+	pool := lookupTransportPool(transport)
+	if pool == nil {
+		t.Fatal("pool stats are not collected with PoolStatInterval")
+	}
+
+	c := &http.Client{Transport: MonitoredRoundTripper(transport)}
+	for i := 0; i < 3; i++ {
+		get(t, c, srv.URL)
+	}
+	if atomic.LoadInt32(&dials) != 1 {
+		t.Errorf("user dialer is called %d times, want 1", dials)
+	}
+
+	pool.mu.Lock()
+	gotConns, reused := pool.gotConns, pool.reusedConns
+	var conns, active int
+	for _, h := range pool.hosts {
+		for pc := range h.conns {
+			conns++
+			if pc.streams > 0 {
+				active++
+			}
+		}
+	}
+	pool.mu.Unlock()
+	if gotConns != 3 || reused != 2 {
+		t.Errorf("got %d conns, %d reused, want 3, 2", gotConns, reused)
+	}
+	if conns != 1 || active != 0 {
+		t.Errorf("pool has %d conns, %d active, want 1 idle", conns, active)
+	}
+}
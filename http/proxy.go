@@ -0,0 +1,170 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+)
+
+// monitoredProxy creates incoming tracker for every proxied request,
+// upstream call becomes its outgoing child
+type monitoredProxy struct {
+	proxy httputil.ReverseProxy
+}
+
+// proxyState of a single proxied request
+type proxyState struct {
+	tracker        httpEventTracker
+	upstream       string
+	upstreamStatus int
+	sent, received int64
+}
+
+// counts bytes passing through request/response body
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+type proxyTransport struct {
+	http.RoundTripper
+}
+
+type proxyStateKey struct{}
+
+var (
+	HeaderParentID = "X-Gomon-Parent-Id"
+
+	KeyUpstream            = "upstream"
+	KeyUpstreamStatus      = "upstream-status"
+	KeyUpstreamSent        = "upstream-sent"
+	KeyUpstreamReceived    = "upstream-received"
+	KeyProxyError          = "proxy-error"
+	KeyModifyResponseError = "modify-response-error"
+)
+
+// MonitoredReverseProxy wraps proxy, given proxy is copied and
+// should not be changed afterwards
+func MonitoredReverseProxy(proxy *httputil.ReverseProxy) http.Handler {
+	p := &monitoredProxy{
+		proxy: *proxy,
+	}
+
+	transport := p.proxy.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	p.proxy.Transport = &proxyTransport{MonitoredRoundTripper(transport)}
+	p.proxy.ErrorHandler = wrapProxyErrorHandler(p.proxy.ErrorHandler, p.proxy.ErrorLog)
+	p.proxy.ModifyResponse = wrapProxyModifyResponse(p.proxy.ModifyResponse)
+	return p
+}
+
+func (p *monitoredProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	config, monitor := loadConfig().ForRequest(r)
+	if !monitor {
+		p.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	tracker, w, r := StartIncomingRequest(w, r, config)
+	tracker.SetFingerprint("http-reverseproxy")
+	defer tracker.Finish()
+
+	st := &proxyState{tracker: tracker}
+	r = r.WithContext(context.WithValue(r.Context(), proxyStateKey{}, st))
+	defer st.fill()
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+func proxyStateFromContext(ctx context.Context) *proxyState {
+	st, _ := ctx.Value(proxyStateKey{}).(*proxyState)
+	return st
+}
+
+func (st *proxyState) fill() {
+	if len(st.upstream) > 0 {
+		st.tracker.Set(KeyUpstream, st.upstream)
+	}
+	if st.upstreamStatus > 0 {
+		st.tracker.Set(KeyUpstreamStatus, st.upstreamStatus)
+	}
+	st.tracker.Set(KeyUpstreamSent, atomic.LoadInt64(&st.sent))
+	st.tracker.Set(KeyUpstreamReceived, atomic.LoadInt64(&st.received))
+}
+
+func (t *proxyTransport) RoundTrip(r *http.Request) (resp *http.Response, err error) {
+	st := proxyStateFromContext(r.Context())
+	if st == nil {
+		return t.RoundTripper.RoundTrip(r)
+	}
+
+	// RoundTripper should not modify given request
+	r = r.Clone(r.Context())
+	r.Header.Set(HeaderParentID, st.tracker.ID().String())
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{r.Body, &st.sent}
+	}
+	st.upstream = r.URL.Host
+
+	resp, err = t.RoundTripper.RoundTrip(r)
+	if err != nil {
+		return
+	}
+
+	st.upstreamStatus = resp.StatusCode
+	if resp.Body != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = &countingBody{resp.Body, &st.received}
+	}
+	return
+}
+
+func (b *countingBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return
+}
+
+func wrapProxyErrorHandler(f func(http.ResponseWriter, *http.Request, error), logger *log.Logger) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if st := proxyStateFromContext(r.Context()); st != nil {
+			st.tracker.Set(KeyProxyError, err.Error())
+			st.tracker.AddError(err)
+		}
+
+		if f != nil {
+			f(w, r, err)
+			return
+		}
+
+		// same as default handler of httputil.ReverseProxy
+		if logger != nil {
+			logger.Printf("http: proxy error: %v", err)
+		} else {
+			log.Printf("http: proxy error: %v", err)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+func wrapProxyModifyResponse(f func(*http.Response) error) func(*http.Response) error {
+	if f == nil {
+		return nil
+	}
+
+	return func(resp *http.Response) (err error) {
+		err = f(resp)
+		if err == nil || resp.Request == nil {
+			return
+		}
+
+		if st := proxyStateFromContext(resp.Request.Context()); st != nil {
+			st.tracker.Set(KeyModifyResponseError, err.Error())
+		}
+		return
+	}
+}
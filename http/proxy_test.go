@@ -0,0 +1,85 @@
+package http
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/iahmedov/gomon"
+)
+
+func TestReverseProxy(t *testing.T) {
+	events.reset()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, r.Header.Get(HeaderParentID))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.Transport = &http.Transport{}
+	srv := httptest.NewServer(MonitoredReverseProxy(rp))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parentID, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	et := events.wait(t, "http-reverseproxy", 1)[0]
+	if string(parentID) != et.ID().String() {
+		t.Errorf("upstream got parent id %q, want %q", parentID, et.ID().String())
+	}
+	if host := et.Get(KeyUpstream); host != target.Host {
+		t.Errorf("upstream = %v, want %s", host, target.Host)
+	}
+	if status := et.Get(KeyUpstreamStatus); status != 200 {
+		t.Errorf("upstream status = %v, want 200", status)
+	}
+	if sent, received := et.Get(KeyUpstreamSent), et.Get(KeyUpstreamReceived); sent != int64(4) || received != int64(len(parentID)) {
+		t.Errorf("sent, received = %v, %v, want 4, %d", sent, received, len(parentID))
+	}
+
+	// upstream call is a child of proxy tracker
+	events.waitMatch(t, "http-roundtripper", 1, func(trip gomon.EventTracker) bool {
+		return isChild(trip, et)
+	})
+}
+
+func TestReverseProxyError(t *testing.T) {
+	events.reset()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	target, _ := url.Parse(upstream.URL)
+	upstream.Close()
+
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.Transport = &http.Transport{}
+	rp.ErrorLog = log.New(io.Discard, "", 0)
+	srv := httptest.NewServer(MonitoredReverseProxy(rp))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", resp.StatusCode)
+	}
+
+	et := events.wait(t, "http-reverseproxy", 1)[0]
+	if et.Get(KeyProxyError) == nil || et.Get(gomon.KeyErrors) == nil {
+		t.Errorf("proxy error = %v, errors = %v", et.Get(KeyProxyError), et.Get(gomon.KeyErrors))
+	}
+	if status := et.Get(KeyUpstreamStatus); status != nil {
+		t.Errorf("upstream status = %v, want none", status)
+	}
+}
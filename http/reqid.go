@@ -0,0 +1,56 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iahmedov/gomon"
+)
+
+type requestIDKey struct{}
+
+var (
+	HeaderRequestID    = "X-Request-ID"
+	HeaderServerTiming = "Server-Timing"
+	KeyRequestID       = "request-id"
+)
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns request id of incoming request if
+// PluginConfig.RequestID is enabled, empty string otherwise
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func incomingRequestID(r *http.Request) string {
+	if id := r.Header.Get(HeaderRequestID); len(id) > 0 {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// serverTimingHeader formats timings as described in
+// https://www.w3.org/TR/server-timing/, durations are in milliseconds
+func serverTimingHeader(t *gomon.Timings) string {
+	metrics := []string{serverTimingMetric("total", t.Total(), 0)}
+	t.Each(func(name string, lapsed time.Duration, count int) {
+		metrics = append(metrics, serverTimingMetric(name, lapsed, count))
+	})
+	return strings.Join(metrics, ", ")
+}
+
+func serverTimingMetric(name string, lapsed time.Duration, count int) string {
+	ms := float64(lapsed) / float64(time.Millisecond)
+	if count > 0 {
+		return fmt.Sprintf("%s;desc=\"%d calls\";dur=%.3f", name, count, ms)
+	}
+	return fmt.Sprintf("%s;dur=%.3f", name, ms)
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestIDPropagation(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get(HeaderRequestID))
+	}))
+	defer backend.Close()
+
+	client := MonitoredClient(&http.Client{Transport: &http.Transport{}})
+	handler := NewMonitoringHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", backend.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// timing of the call is added when its body is closed
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		w.Write(b)
+	})).(*wrappedMux)
+	handler.config = &PluginConfig{RequestID: true, ServerTiming: true}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	for _, id := range []string{"abc", ""} {
+		events.reset()
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		if len(id) > 0 {
+			req.Header.Set(HeaderRequestID, id)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backendID, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		got := resp.Header.Get(HeaderRequestID)
+		if len(got) == 0 || (len(id) > 0 && got != id) {
+			t.Errorf("response request id = %q, want %q", got, id)
+		}
+		if string(backendID) != got {
+			t.Errorf("backend got request id %q, want %q", backendID, got)
+		}
+		if et := events.wait(t, "http-wmux-servehttp", 1)[0]; et.Get(KeyRequestID) != got {
+			t.Errorf("tracker request id = %v, want %q", et.Get(KeyRequestID), got)
+		}
+
+		timing := resp.Header.Get(HeaderServerTiming)
+		if !strings.HasPrefix(timing, "total;dur=") || !strings.Contains(timing, `http;desc="1 calls";dur=`) {
+			t.Errorf("Server-Timing = %q", timing)
+		}
+	}
+}
+
+func TestServerTimingMetric(t *testing.T) {
+	if m := serverTimingMetric("db", 1500*time.Microsecond, 2); m != `db;desc="2 calls";dur=1.500` {
+		t.Errorf("metric = %s", m)
+	}
+	if m := serverTimingMetric("total", 20*time.Millisecond, 0); m != "total;dur=20.000" {
+		t.Errorf("metric = %s", m)
+	}
+}
@@ -0,0 +1,107 @@
+package revel
+
+import (
+	"sync/atomic"
+
+	"github.com/iahmedov/gomon"
+	gomonhttp "github.com/iahmedov/gomon/http"
+	"github.com/revel/revel"
+)
+
+func init() {
+	gomon.SetConfigFunc(pluginName, SetConfig)
+}
+
+// PluginConfig is the same as gomon/http.PluginConfig,
+// separate type lets revel be configured independently
+type PluginConfig struct {
+	gomonhttp.PluginConfig
+}
+
+// monitoredResult finishes request tracker after
+// result is written to the response
+type monitoredResult struct {
+	revel.Result
+	finish func()
+}
+
+var defaultConfig = &PluginConfig{
+	gomonhttp.PluginConfig{
+		RequestHeaders:  true,
+		RespBody:        true,
+		RespBodyMaxSize: 1024,
+		RespHeaders:     true,
+		RespCode:        true,
+	},
+}
+
+// config set by SetConfig, loaded once per request
+var currentConfig atomic.Value // *PluginConfig
+
+var pluginName = "http-revel"
+
+var (
+	KeyController = "controller"
+	KeyAction     = "action"
+)
+
+func SetConfig(c gomon.TrackerConfig) {
+	if conf, ok := c.(*PluginConfig); ok {
+		currentConfig.Store(conf)
+	} else {
+		panic("not compatible config")
+	}
+}
+
+func loadConfig() *PluginConfig {
+	if conf, ok := currentConfig.Load().(*PluginConfig); ok {
+		return conf
+	}
+	return defaultConfig
+}
+
+func (p *PluginConfig) Name() string {
+	return pluginName
+}
+
+// Filter should be the first one in revel.Filters, or right after
+// revel.PanicFilter to see responses of recovered panics
+func Filter(c *revel.Controller, fc []revel.Filter) {
+	config, monitor := loadConfig().ForRequest(c.Request.Request)
+	if !monitor {
+		fc[0](c, fc[1:])
+		return
+	}
+
+	et, w, r := gomonhttp.StartIncomingRequest(c.Response.Out, c.Request.Request, config)
+	et.SetFingerprint("revel-handle")
+	c.Response.Out = w
+	c.Request.Request = r
+
+	finish := func() {
+		if len(c.Name) > 0 {
+			et.Set(KeyController, c.Name)
+			et.Set(KeyAction, c.MethodName)
+		}
+		// revel routes are identified by their action
+		if len(c.Action) > 0 {
+			et.Set(gomonhttp.KeyRoute, c.Action)
+		}
+		et.Finish()
+	}
+	defer func() {
+		// revel applies result after all filters are done
+		if c.Result == nil {
+			finish()
+			return
+		}
+		c.Result = &monitoredResult{c.Result, finish}
+	}()
+
+	fc[0](c, fc[1:])
+}
+
+func (r *monitoredResult) Apply(req *revel.Request, resp *revel.Response) {
+	defer r.finish()
+	r.Result.Apply(req, resp)
+}
@@ -0,0 +1,75 @@
+package revel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gomonhttp "github.com/iahmedov/gomon/http"
+	"github.com/revel/revel"
+)
+
+type textResult string
+
+func (r textResult) Apply(req *revel.Request, resp *revel.Response) {
+	resp.WriteHeader(http.StatusCreated, "text/plain")
+	resp.Out.Write([]byte(r))
+}
+
+// serve emulates revel request handling, result is applied
+// after filters are done
+func serve(action revel.Filter) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := &revel.Controller{
+			Request:  &revel.Request{Request: r},
+			Response: &revel.Response{Out: w},
+		}
+		Filter(c, []revel.Filter{action})
+		if c.Result != nil {
+			c.Result.Apply(c.Request, c.Response)
+		}
+	}))
+}
+
+func get(t *testing.T, url string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestFilterResult(t *testing.T) {
+	events.reset()
+	srv := serve(func(c *revel.Controller, fc []revel.Filter) {
+		c.Name, c.MethodName, c.Action = "App", "Index", "App.Index"
+		c.Result = textResult("ok")
+	})
+	defer srv.Close()
+	get(t, srv.URL)
+
+	et := events.wait(t, "revel-handle", 1)[0]
+	if route := et.Get(gomonhttp.KeyRoute); route != "App.Index" {
+		t.Errorf("route = %v, want App.Index", route)
+	}
+	if controller, action := et.Get(KeyController), et.Get(KeyAction); controller != "App" || action != "Index" {
+		t.Errorf("controller, action = %v, %v, want App, Index", controller, action)
+	}
+	// tracker is finished after result is applied
+	if code := et.Get(gomonhttp.KeyResponseCode); code != http.StatusCreated {
+		t.Errorf("response code = %v, want 201", code)
+	}
+}
+
+func TestFilterWithoutResult(t *testing.T) {
+	events.reset()
+	srv := serve(func(c *revel.Controller, fc []revel.Filter) {})
+	defer srv.Close()
+	get(t, srv.URL)
+
+	et := events.wait(t, "revel-handle", 1)[0]
+	if controller := et.Get(KeyController); controller != nil {
+		t.Errorf("controller = %v, want none", controller)
+	}
+}
@@ -0,0 +1,147 @@
+package http
+
+import (
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// responseStream is created when response turns out to be a stream,
+// either by content type (text/event-stream) or by repeated flushes
+// of a response without Content-Length. Body is not captured for streams
+type responseStream struct {
+	started      time.Time
+	flushGaps    *gomon.Histogram
+	disconnected time.Duration
+	done         chan struct{}
+}
+
+// flushes of non event-stream response before it is
+// considered to be a stream
+const kStreamFlushes = 2
+
+var (
+	KeyStreaming          = "streaming"
+	KeyFlushes            = "flushes"
+	KeyFlushGaps          = "flush-gaps"
+	KeyResponseSize       = "resp-size"
+	KeyResponseFirstByte  = "resp-first-byte-time"
+	KeyClientDisconnected = "client-disconnected"
+	KeyDisconnectedAfter  = "disconnected-after"
+)
+
+func (r *wrappedResponseWriter) streaming() bool {
+	r.streamMu.Lock()
+	defer r.streamMu.Unlock()
+	return r.stream != nil
+}
+
+func (r *wrappedResponseWriter) startStream() {
+	r.streamMu.Lock()
+	defer r.streamMu.Unlock()
+	if r.stream != nil {
+		return
+	}
+
+	r.stream = &responseStream{
+		started:   time.Now(),
+		flushGaps: gomon.NewHistogram(nil),
+		done:      make(chan struct{}),
+	}
+	// whatever was captured so far is just the beginning of the stream
+	r.body.Reset()
+	r.tracker.Set(KeyStreaming, true)
+
+	go r.watchStream(r.stream, r.config.StreamProgressInterval)
+}
+
+// watchStream records client disconnect and sends progress
+// events until request is finished
+func (r *wrappedResponseWriter) watchStream(stream *responseStream, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-stream.done:
+			return
+		case <-r.ctx.Done():
+			r.streamMu.Lock()
+			stream.disconnected = time.Since(stream.started)
+			r.streamMu.Unlock()
+			return
+		case <-tick:
+			r.progress()
+		}
+	}
+}
+
+func (r *wrappedResponseWriter) progress() {
+	et := r.tracker.NewChild(false)
+	et.SetFingerprint("http-stream-progress")
+	r.streamMu.Lock()
+	r.fillStream(et)
+	r.streamMu.Unlock()
+	et.Finish()
+}
+
+func (r *wrappedResponseWriter) wrote(n int) {
+	r.streamMu.Lock()
+	defer r.streamMu.Unlock()
+	if r.written == 0 && n > 0 {
+		r.firstByte = time.Since(r.start)
+	}
+	r.written += int64(n)
+}
+
+func (r *wrappedResponseWriter) flushed() {
+	r.streamMu.Lock()
+	now := time.Now()
+	if r.stream != nil && !r.lastFlush.IsZero() {
+		r.stream.flushGaps.Observe(now.Sub(r.lastFlush))
+	}
+	r.lastFlush = now
+	r.flushes++
+	start := r.stream == nil && r.flushes >= kStreamFlushes &&
+		len(r.Header().Get("Content-Length")) == 0
+	r.streamMu.Unlock()
+
+	if start {
+		r.startStream()
+	}
+}
+
+// fillStream is called with streamMu held
+func (r *wrappedResponseWriter) fillStream(et gomon.EventTracker) {
+	et.Set(KeyResponseSize, r.written)
+	if r.written > 0 {
+		et.Set(KeyResponseFirstByte, r.firstByte)
+	}
+	if r.stream == nil {
+		return
+	}
+
+	et.Set(KeyFlushes, r.flushes)
+	et.Set(KeyFlushGaps, r.stream.flushGaps.KVData())
+	if r.stream.disconnected > 0 {
+		et.Set(KeyClientDisconnected, true)
+		et.Set(KeyDisconnectedAfter, r.stream.disconnected)
+	}
+}
+
+// finish is called right before request tracker is finished
+func (r *wrappedResponseWriter) finish() {
+	r.streamMu.Lock()
+	defer r.streamMu.Unlock()
+	if r.stream != nil {
+		close(r.stream.done)
+		if r.stream.disconnected == 0 && r.ctx.Err() != nil {
+			r.stream.disconnected = time.Since(r.stream.started)
+		}
+	}
+	r.fillStream(r.tracker)
+}
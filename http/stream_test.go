@@ -0,0 +1,107 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+func serveMonitored(config *PluginConfig, h http.HandlerFunc) *httptest.Server {
+	handler := NewMonitoringHandler(h).(*wrappedMux)
+	handler.config = config
+	return httptest.NewServer(handler)
+}
+
+// incoming matches incoming request events of path
+func incoming(path string) func(gomon.EventTracker) bool {
+	return func(et gomon.EventTracker) bool {
+		u, _ := et.Get(KeyURL).(map[string]interface{})
+		return u["path"] == path
+	}
+}
+
+func TestStreamDetection(t *testing.T) {
+	cases := []struct {
+		path        string
+		contentType string
+		flushes     int
+		stream      bool
+	}{
+		{"/event-stream", "text/event-stream", 1, true},
+		{"/flushed", "text/plain", 3, true},
+		{"/flushed-once", "text/plain", 1, false},
+		{"/plain", "text/plain", 0, false},
+	}
+
+	config := &PluginConfig{RespBody: true, RespBodyMaxSize: 1024}
+	srv := serveMonitored(config, func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range cases {
+			if c.path != r.URL.Path {
+				continue
+			}
+			w.Header().Set("Content-Type", c.contentType)
+			io.WriteString(w, "data: 1\n\n")
+			for i := 0; i < c.flushes; i++ {
+				w.(http.Flusher).Flush()
+				io.WriteString(w, "data: 2\n\n")
+			}
+		}
+	})
+	defer srv.Close()
+
+	for _, c := range cases {
+		events.reset()
+		get(t, srv.Client(), srv.URL+c.path)
+
+		et := events.waitMatch(t, "http-wmux-servehttp", 1, incoming(c.path))[0]
+		if streaming := et.Get(KeyStreaming) == true; streaming != c.stream {
+			t.Errorf("%s: streaming = %v, want %v", c.path, streaming, c.stream)
+		}
+		size := int64(9 * (c.flushes + 1))
+		if got := et.Get(KeyResponseSize); got != size {
+			t.Errorf("%s: response size = %v, want %d", c.path, got, size)
+		}
+		body, _ := et.Get(KeyResponseBody).(*bytes.Buffer)
+		if c.stream && (body == nil || body.Len() != 0) {
+			t.Errorf("%s: body of stream is captured", c.path)
+		}
+		if c.stream && et.Get(KeyFlushes) != int64(c.flushes) {
+			t.Errorf("%s: flushes = %v, want %d", c.path, et.Get(KeyFlushes), c.flushes)
+		}
+	}
+}
+
+func TestStreamProgressAndDisconnect(t *testing.T) {
+	events.reset()
+	srv := serveMonitored(&PluginConfig{StreamProgressInterval: 10 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := events.wait(t, "http-stream-progress", 1)[0].Parent()
+	cancel()
+	resp.Body.Close()
+
+	et := events.waitMatch(t, "http-wmux-servehttp", 1, incoming("/events"))[0]
+	if *parent != et.ID() {
+		t.Error("progress event is not a child of request tracker")
+	}
+	if et.Get(KeyClientDisconnected) != true || et.Get(KeyDisconnectedAfter) == nil {
+		t.Errorf("client disconnect is not recorded: %v, %v",
+			et.Get(KeyClientDisconnected), et.Get(KeyDisconnectedAfter))
+	}
+}
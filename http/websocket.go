@@ -0,0 +1,362 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// wsConn parses websocket frame headers (RFC 6455) of hijacked
+// connection in both directions, payload is not kept except
+// first 2 bytes of close frames (close code)
+type wsConn struct {
+	net.Conn
+	et gomon.EventTracker
+
+	mu          sync.Mutex
+	in, out     *wsFrameParser
+	inStat      wsDirectionStat
+	outStat     wsDirectionStat
+	pings       []time.Time
+	pingLatency *gomon.Histogram
+	closeCode   int
+	closedBy    string
+	once        sync.Once
+}
+
+type wsDirectionStat struct {
+	frames   int64
+	bytes    int64
+	messages map[string]int64
+}
+
+type wsFrameParser struct {
+	// server usually writes handshake response to hijacked connection,
+	// it is skipped until the end of http headers
+	handshake     bool
+	handshakeSeen bool
+	handshakeN    int
+
+	hdr    [14]byte
+	hdrLen int
+	need   int
+
+	inPayload   bool
+	opcode      byte
+	fin         bool
+	mask        [4]byte
+	masked      bool
+	payloadLen  uint64
+	payloadPos  uint64
+	closeBuf    []byte
+	msgOpcode   byte
+	onFrameDone func(p *wsFrameParser)
+}
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// pings waiting for pong, older ones are dropped
+const kMaxWSPings = 16
+
+var (
+	KeyWebSocketIn          = "ws-in"
+	KeyWebSocketOut         = "ws-out"
+	KeyWebSocketPingLatency = "ws-ping-latency"
+	KeyWebSocketCloseCode   = "ws-close-code"
+	KeyWebSocketClosedBy    = "ws-closed-by"
+)
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsOpcodeName(op byte) string {
+	switch op {
+	case wsOpContinuation:
+		return "continuation"
+	case wsOpText:
+		return "text"
+	case wsOpBinary:
+		return "binary"
+	case wsOpClose:
+		return "close"
+	case wsOpPing:
+		return "ping"
+	case wsOpPong:
+		return "pong"
+	}
+	return "unknown"
+}
+
+func newWebSocketConn(c net.Conn, parent gomon.EventTracker) *wsConn {
+	et := parent.NewChild(false)
+	et.SetFingerprint("http-websocket")
+	ws := &wsConn{
+		Conn:        c,
+		et:          et,
+		inStat:      wsDirectionStat{messages: make(map[string]int64)},
+		outStat:     wsDirectionStat{messages: make(map[string]int64)},
+		pingLatency: gomon.NewHistogram(nil),
+	}
+	ws.in = newWsFrameParser(false, func(p *wsFrameParser) { ws.frameDone(p, &ws.inStat, "client") })
+	ws.out = newWsFrameParser(true, func(p *wsFrameParser) { ws.frameDone(p, &ws.outStat, "server") })
+	return ws
+}
+
+// hijackedReadWriter replaces bufio.ReadWriter returned by Hijack,
+// since it reads/writes directly from the original connection
+func hijackedReadWriter(b *bufio.ReadWriter, c net.Conn, ws *wsConn) *bufio.ReadWriter {
+	if b == nil {
+		return nil
+	}
+
+	var r io.Reader = c
+	if n := b.Reader.Buffered(); n > 0 {
+		buffered, _ := b.Reader.Peek(n)
+		buffered = append([]byte(nil), buffered...)
+		if ws != nil {
+			ws.observe(ws.in, &ws.inStat, buffered)
+		}
+		r = io.MultiReader(bytes.NewReader(buffered), c)
+	}
+	b.Writer.Flush()
+
+	return bufio.NewReadWriter(
+		bufio.NewReaderSize(r, b.Reader.Size()),
+		bufio.NewWriterSize(c, b.Writer.Size()),
+	)
+}
+
+func (ws *wsConn) Read(p []byte) (n int, err error) {
+	n, err = ws.Conn.Read(p)
+	if n > 0 {
+		ws.observe(ws.in, &ws.inStat, p[:n])
+	}
+	return
+}
+
+func (ws *wsConn) Write(p []byte) (n int, err error) {
+	n, err = ws.Conn.Write(p)
+	if n > 0 {
+		ws.observe(ws.out, &ws.outStat, p[:n])
+	}
+	return
+}
+
+func (ws *wsConn) Close() (err error) {
+	err = ws.Conn.Close()
+	if err != nil {
+		ws.et.AddError(err)
+	}
+	ws.finish()
+	return
+}
+
+func (ws *wsConn) observe(p *wsFrameParser, stat *wsDirectionStat, b []byte) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	stat.bytes += int64(len(b))
+	p.feed(b)
+}
+
+// called by parser with ws.mu held
+func (ws *wsConn) frameDone(p *wsFrameParser, stat *wsDirectionStat, sender string) {
+	stat.frames++
+	switch {
+	case p.opcode >= wsOpClose:
+		// control frames are never fragmented
+		stat.messages[wsOpcodeName(p.opcode)]++
+	case p.fin:
+		stat.messages[wsOpcodeName(p.msgOpcode)]++
+	}
+
+	switch p.opcode {
+	case wsOpPing:
+		if sender == "server" {
+			if len(ws.pings) == kMaxWSPings {
+				// peer does not answer, oldest ping is forgotten
+				n := copy(ws.pings, ws.pings[1:])
+				ws.pings = ws.pings[:n]
+			}
+			ws.pings = append(ws.pings, time.Now())
+		}
+	case wsOpPong:
+		if sender == "client" && len(ws.pings) > 0 {
+			ws.pingLatency.Observe(time.Since(ws.pings[0]))
+			ws.pings = ws.pings[1:]
+		}
+	case wsOpClose:
+		if len(ws.closedBy) == 0 {
+			ws.closedBy = sender
+			if len(p.closeBuf) == 2 {
+				ws.closeCode = int(binary.BigEndian.Uint16(p.closeBuf))
+			}
+		}
+	}
+}
+
+func (ws *wsConn) finish() {
+	ws.once.Do(func() {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		ws.et.Set(KeyWebSocketIn, ws.inStat.KVData())
+		ws.et.Set(KeyWebSocketOut, ws.outStat.KVData())
+		if ws.pingLatency.Count() > 0 {
+			ws.et.Set(KeyWebSocketPingLatency, ws.pingLatency.KVData())
+		}
+		if len(ws.closedBy) > 0 {
+			ws.et.Set(KeyWebSocketClosedBy, ws.closedBy)
+			ws.et.Set(KeyWebSocketCloseCode, ws.closeCode)
+		}
+		ws.et.Finish()
+	})
+}
+
+func (s *wsDirectionStat) KVData() map[string]interface{} {
+	return map[string]interface{}{
+		"frames":   s.frames,
+		"bytes":    s.bytes,
+		"messages": s.messages,
+	}
+}
+
+func newWsFrameParser(handshake bool, onFrameDone func(p *wsFrameParser)) *wsFrameParser {
+	return &wsFrameParser{
+		handshake:   handshake,
+		need:        2,
+		onFrameDone: onFrameDone,
+	}
+}
+
+var httpHeaderEnd = []byte("\r\n\r\n")
+
+func (p *wsFrameParser) feed(b []byte) {
+	if p.handshake && !p.handshakeSeen && len(b) > 0 {
+		// handshake was sent before hijack, frames start right away
+		p.handshake = b[0] == 'H'
+		p.handshakeSeen = true
+	}
+
+	for p.handshake && len(b) > 0 {
+		if b[0] == httpHeaderEnd[p.handshakeN] {
+			p.handshakeN++
+		} else if b[0] == httpHeaderEnd[0] {
+			p.handshakeN = 1
+		} else {
+			p.handshakeN = 0
+		}
+		b = b[1:]
+		p.handshake = p.handshakeN < len(httpHeaderEnd)
+	}
+
+	for len(b) > 0 {
+		if !p.inPayload {
+			k := copy(p.hdr[p.hdrLen:p.need], b)
+			p.hdrLen += k
+			b = b[k:]
+			if p.hdrLen < p.need {
+				continue
+			}
+
+			if p.hdrLen == 2 {
+				p.need = 2
+				switch p.hdr[1] & 0x7f {
+				case 126:
+					p.need += 2
+				case 127:
+					p.need += 8
+				}
+				if p.hdr[1]&0x80 != 0 {
+					p.need += 4
+				}
+				if p.need > 2 {
+					continue
+				}
+			}
+			p.frameStart()
+			continue
+		}
+
+		k := uint64(len(b))
+		if left := p.payloadLen - p.payloadPos; k > left {
+			k = left
+		}
+		if p.opcode == wsOpClose {
+			for i := uint64(0); i < k && len(p.closeBuf) < 2; i++ {
+				c := b[i]
+				if p.masked {
+					c ^= p.mask[(p.payloadPos+i)%4]
+				}
+				p.closeBuf = append(p.closeBuf, c)
+			}
+		}
+		p.payloadPos += k
+		b = b[k:]
+		if p.payloadPos == p.payloadLen {
+			p.frameDone()
+		}
+	}
+}
+
+func (p *wsFrameParser) frameStart() {
+	p.fin = p.hdr[0]&0x80 != 0
+	p.opcode = p.hdr[0] & 0x0f
+	p.masked = p.hdr[1]&0x80 != 0
+
+	pos := 2
+	switch l := p.hdr[1] & 0x7f; l {
+	case 126:
+		p.payloadLen = uint64(binary.BigEndian.Uint16(p.hdr[2:4]))
+		pos += 2
+	case 127:
+		p.payloadLen = binary.BigEndian.Uint64(p.hdr[2:10])
+		pos += 8
+	default:
+		p.payloadLen = uint64(l)
+	}
+	if p.masked {
+		copy(p.mask[:], p.hdr[pos:pos+4])
+	}
+
+	if p.opcode != wsOpContinuation && p.opcode < wsOpClose {
+		p.msgOpcode = p.opcode
+	}
+	p.payloadPos = 0
+	p.closeBuf = p.closeBuf[:0]
+	p.inPayload = true
+	if p.payloadLen == 0 {
+		p.frameDone()
+	}
+}
+
+func (p *wsFrameParser) frameDone() {
+	p.onFrameDone(p)
+	p.inPayload = false
+	p.hdrLen = 0
+	p.need = 2
+}
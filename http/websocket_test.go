@@ -0,0 +1,132 @@
+package http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/iahmedov/gomon"
+)
+
+type wsTestFrame struct {
+	opcode    byte
+	fin       bool
+	msgOpcode byte
+	length    uint64
+	close     []byte
+}
+
+// wsFrame encodes frame, masked frames are sent by clients
+func wsFrame(opcode byte, fin, masked bool, payload []byte) []byte {
+	var b bytes.Buffer
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	b.WriteByte(first)
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b.WriteByte(maskBit | byte(n))
+	case n <= 0xffff:
+		b.WriteByte(maskBit | 126)
+		binary.Write(&b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(maskBit | 127)
+		binary.Write(&b, binary.BigEndian, uint64(n))
+	}
+
+	if !masked {
+		b.Write(payload)
+		return b.Bytes()
+	}
+	mask := []byte{1, 2, 3, 4}
+	b.Write(mask)
+	for i, c := range payload {
+		b.WriteByte(c ^ mask[i%4])
+	}
+	return b.Bytes()
+}
+
+func TestWsFrameParser(t *testing.T) {
+	stream := bytes.Join([][]byte{
+		[]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"),
+		wsFrame(wsOpText, true, false, []byte("hello")),
+		wsFrame(wsOpBinary, false, true, make([]byte, 300)),
+		wsFrame(wsOpPing, true, true, nil),
+		wsFrame(wsOpContinuation, true, true, make([]byte, 70000)),
+		wsFrame(wsOpClose, true, true, []byte{0x03, 0xe8, 'b', 'y', 'e'}),
+	}, nil)
+	want := []wsTestFrame{
+		{wsOpText, true, wsOpText, 5, nil},
+		{wsOpBinary, false, wsOpBinary, 300, nil},
+		{wsOpPing, true, wsOpBinary, 0, nil},
+		{wsOpContinuation, true, wsOpBinary, 70000, nil},
+		{wsOpClose, true, wsOpBinary, 5, []byte{0x03, 0xe8}},
+	}
+
+	// all at once, byte by byte and in small chunks
+	for _, chunk := range []int{len(stream), 1, 7} {
+		var got []wsTestFrame
+		p := newWsFrameParser(true, func(p *wsFrameParser) {
+			got = append(got, wsTestFrame{
+				opcode:    p.opcode,
+				fin:       p.fin,
+				msgOpcode: p.msgOpcode,
+				length:    p.payloadLen,
+				close:     append([]byte(nil), p.closeBuf...),
+			})
+		})
+		for b := stream; len(b) > 0; {
+			n := chunk
+			if n > len(b) {
+				n = len(b)
+			}
+			p.feed(b[:n])
+			b = b[n:]
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("chunk %d: got %d frames, want %d", chunk, len(got), len(want))
+		}
+		for i := range want {
+			g, w := got[i], want[i]
+			if g.opcode != w.opcode || g.fin != w.fin || g.msgOpcode != w.msgOpcode ||
+				g.length != w.length || !bytes.Equal(g.close, w.close) {
+				t.Errorf("chunk %d: frame %d = %+v, want %+v", chunk, i, g, w)
+			}
+		}
+	}
+}
+
+func TestWsFrameParserNoHandshake(t *testing.T) {
+	frames := 0
+	p := newWsFrameParser(true, func(p *wsFrameParser) { frames++ })
+	p.feed(wsFrame(wsOpText, true, false, []byte("hi")))
+	p.feed(wsFrame(wsOpPong, true, false, nil))
+	if frames != 2 {
+		t.Errorf("got %d frames, want 2", frames)
+	}
+}
+
+func TestWebSocketPings(t *testing.T) {
+	ws := newWebSocketConn(nil, gomon.FromContext(nil))
+	for i := 0; i < 2*kMaxWSPings; i++ {
+		ws.observe(ws.out, &ws.outStat, wsFrame(wsOpPing, true, false, nil))
+	}
+	if len(ws.pings) != kMaxWSPings {
+		t.Errorf("%d pings are waiting for pong, want %d", len(ws.pings), kMaxWSPings)
+	}
+
+	ws.observe(ws.in, &ws.inStat, wsFrame(wsOpPong, true, true, nil))
+	if len(ws.pings) != kMaxWSPings-1 || ws.pingLatency.Count() != 1 {
+		t.Errorf("pong is not matched with ping")
+	}
+	if ws.outStat.messages["ping"] != 2*kMaxWSPings || ws.inStat.messages["pong"] != 1 {
+		t.Errorf("messages = %v, %v", ws.outStat.messages, ws.inStat.messages)
+	}
+}
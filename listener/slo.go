@@ -0,0 +1,385 @@
+package listener
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+// SLOObjectives of a route.
+// Apdex: requests faster than ApdexThreshold are satisfied,
+// faster than 4*ApdexThreshold tolerating, rest are frustrated.
+// Availability: requests answered with 5xx are bad.
+// Latency: requests slower than LatencyThreshold are bad
+type SLOObjectives struct {
+	ApdexThreshold        time.Duration
+	LatencyThreshold      time.Duration
+	AvailabilityObjective float64 // e.g. 0.999
+	LatencyObjective      float64 // e.g. 0.99
+}
+
+// BurnRateWindow fires an alert when error budget burn rate is
+// above Threshold in both Long and Short windows
+type BurnRateWindow struct {
+	Long      time.Duration
+	Short     time.Duration
+	Threshold float64
+}
+
+type SLOConfig struct {
+	SLOObjectives
+	// per route objectives, routes not listed here use SLOObjectives
+	Routes map[string]SLOObjectives
+
+	// objective (error budget) window and size of a single bucket,
+	// memory used per route is Window/Resolution buckets
+	Window     time.Duration
+	Resolution time.Duration
+	// routes tracked separately, requests to other routes are
+	// counted as KeySLOOtherRoutes (routes listed in Routes are
+	// always tracked). Without route pattern (KeyRoute) raw url
+	// path is used, so limit should not be removed
+	MaxRoutes int
+
+	BurnRateWindows []BurnRateWindow
+	ReportInterval  time.Duration
+}
+
+type SLOListener struct {
+	config SLOConfig
+
+	mu     sync.Mutex
+	routes map[string]*routeSLO
+
+	stop chan struct{}
+}
+
+type routeSLO struct {
+	objectives SLOObjectives
+	buckets    []sloBucket
+	// firing alerts, key is sli name + index of burn rate window
+	alerts map[sloAlertKey]bool
+}
+
+type sloAlertKey struct {
+	sli    string
+	window int
+}
+
+type sloBucket struct {
+	index                 int64
+	total                 int64
+	errors, slow          int64
+	satisfied, tolerating int64
+}
+
+var DefaultSLOConfig = &SLOConfig{
+	SLOObjectives: SLOObjectives{
+		ApdexThreshold:        500 * time.Millisecond,
+		LatencyThreshold:      time.Second,
+		AvailabilityObjective: 0.999,
+		LatencyObjective:      0.99,
+	},
+	Window:     24 * time.Hour,
+	Resolution: time.Minute,
+	MaxRoutes:  100,
+	BurnRateWindows: []BurnRateWindow{
+		{Long: time.Hour, Short: 5 * time.Minute, Threshold: 14.4},
+		{Long: 6 * time.Hour, Short: 30 * time.Minute, Threshold: 6},
+	},
+	ReportInterval: time.Minute,
+}
+
+var (
+	KeySLORoutes        = "routes"
+	KeySLORoute         = "route"
+	KeySLOIndicator     = "sli"
+	KeySLOFiring        = "firing"
+	KeySLOThreshold     = "threshold"
+	KeySLOLongWindow    = "long-window"
+	KeySLOShortWindow   = "short-window"
+	KeySLOLongBurnRate  = "long-burn-rate"
+	KeySLOShortBurnRate = "short-burn-rate"
+	KeySLOOtherRoutes   = "(other)"
+
+	sliAvailability = "availability"
+	sliLatency      = "latency"
+)
+
+var _ gomon.Listener = (*SLOListener)(nil)
+var _ gomon.ListenerConfig = (*SLOConfig)(nil)
+
+func (c *SLOConfig) CanBePooled() bool {
+	return false
+}
+
+// NewSLOListener computes Apdex and SLO compliance per route from
+// incoming http request events, nil config means DefaultSLOConfig
+func NewSLOListener(config gomon.ListenerConfig) gomon.Listener {
+	conf, ok := config.(*SLOConfig)
+	if !ok || conf == nil {
+		conf = DefaultSLOConfig
+	}
+
+	l := &SLOListener{
+		config: *conf,
+		routes: make(map[string]*routeSLO),
+		stop:   make(chan struct{}),
+	}
+	if l.config.Resolution <= 0 {
+		l.config.Resolution = time.Minute
+	}
+	if l.config.Window < l.config.Resolution {
+		l.config.Window = l.config.Resolution
+	}
+
+	if l.config.ReportInterval > 0 {
+		go l.run()
+	}
+	return l
+}
+
+func (l *SLOListener) Feed(et gomon.EventTracker) {
+	if direction, _ := et.Get(gomonhttp.KeyDirection).(string); direction != "incoming" {
+		return
+	}
+
+	code, ok := et.Get(gomonhttp.KeyResponseCode).(int)
+	if !ok {
+		// response codes are not recorded (PluginConfig.RespCode)
+		return
+	}
+	route := eventRoute(et)
+	lapsed := et.Lapsed()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.routes[route]
+	if !ok {
+		_, configured := l.config.Routes[route]
+		if !configured && l.config.MaxRoutes > 0 && len(l.routes) >= l.config.MaxRoutes {
+			route = KeySLOOtherRoutes
+			r, ok = l.routes[route]
+		}
+	}
+	if !ok {
+		r = l.newRoute(route)
+		l.routes[route] = r
+	}
+	r.add(l.bucketIndex(time.Now()), code, lapsed)
+}
+
+func (l *SLOListener) Stop() {
+	close(l.stop)
+}
+
+func eventRoute(et gomon.EventTracker) string {
+	if route, ok := et.Get(gomonhttp.KeyRoute).(string); ok {
+		return route
+	}
+	if u, ok := et.Get(gomonhttp.KeyURL).(map[string]interface{}); ok {
+		if path, ok := u["path"].(string); ok {
+			return path
+		}
+	}
+	return ""
+}
+
+func (l *SLOListener) newRoute(route string) *routeSLO {
+	objectives, ok := l.config.Routes[route]
+	if !ok {
+		objectives = l.config.SLOObjectives
+	}
+
+	return &routeSLO{
+		objectives: objectives,
+		buckets:    make([]sloBucket, l.buckets(l.config.Window)),
+		alerts:     make(map[sloAlertKey]bool),
+	}
+}
+
+func (l *SLOListener) bucketIndex(t time.Time) int64 {
+	return t.UnixNano() / int64(l.config.Resolution)
+}
+
+func (l *SLOListener) buckets(window time.Duration) int {
+	n := int(window / l.config.Resolution)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (r *routeSLO) add(index int64, code int, lapsed time.Duration) {
+	b := &r.buckets[index%int64(len(r.buckets))]
+	if b.index != index {
+		*b = sloBucket{index: index}
+	}
+
+	b.total++
+	if code >= http.StatusInternalServerError {
+		b.errors++
+	}
+	if r.objectives.LatencyThreshold > 0 && lapsed > r.objectives.LatencyThreshold {
+		b.slow++
+	}
+	if lapsed <= r.objectives.ApdexThreshold {
+		b.satisfied++
+	} else if lapsed <= 4*r.objectives.ApdexThreshold {
+		b.tolerating++
+	}
+}
+
+// sum of the last n buckets ending with index
+func (r *routeSLO) sum(index int64, n int) (s sloBucket) {
+	if n > len(r.buckets) {
+		n = len(r.buckets)
+	}
+	for _, b := range r.buckets {
+		if b.index > index-int64(n) && b.index <= index {
+			s.total += b.total
+			s.errors += b.errors
+			s.slow += b.slow
+			s.satisfied += b.satisfied
+			s.tolerating += b.tolerating
+		}
+	}
+	return
+}
+
+func (b *sloBucket) apdex() float64 {
+	if b.total == 0 {
+		return 1
+	}
+	return (float64(b.satisfied) + float64(b.tolerating)/2) / float64(b.total)
+}
+
+func (b *sloBucket) bad(sli string) int64 {
+	if sli == sliLatency {
+		return b.slow
+	}
+	return b.errors
+}
+
+func (b *sloBucket) compliance(sli string) float64 {
+	if b.total == 0 {
+		return 1
+	}
+	return 1 - float64(b.bad(sli))/float64(b.total)
+}
+
+// burnRate is how fast error budget is spent, 1 means budget
+// is exactly used up by the end of objective window
+func (b *sloBucket) burnRate(sli string, objective float64) float64 {
+	if b.total == 0 || objective <= 0 || objective >= 1 {
+		return 0
+	}
+	return (float64(b.bad(sli)) / float64(b.total)) / (1 - objective)
+}
+
+func (o *SLOObjectives) objective(sli string) float64 {
+	if sli == sliLatency {
+		return o.LatencyObjective
+	}
+	return o.AvailabilityObjective
+}
+
+func (l *SLOListener) run() {
+	ticker := time.NewTicker(l.config.ReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case t := <-ticker.C:
+			l.report(t)
+		}
+	}
+}
+
+func (l *SLOListener) report(now time.Time) {
+	et := gomon.FromContext(nil).NewChild(false)
+	et.SetFingerprint("slo-summary")
+	defer et.Finish()
+
+	index := l.bucketIndex(now)
+	summary := make(map[string]interface{})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for route, r := range l.routes {
+		recent := r.sum(index, l.buckets(l.config.ReportInterval))
+		window := r.sum(index, len(r.buckets))
+		if window.total == 0 {
+			// no traffic during whole objective window
+			l.resolveAlerts(route, r)
+			delete(l.routes, route)
+			continue
+		}
+
+		kv := map[string]interface{}{
+			"requests":        recent.total,
+			"apdex":           recent.apdex(),
+			"window-requests": window.total,
+			"window-apdex":    window.apdex(),
+		}
+		for _, sli := range []string{sliAvailability, sliLatency} {
+			objective := r.objectives.objective(sli)
+			kv[sli] = window.compliance(sli)
+			kv[sli+"-budget-left"] = 1 - window.burnRate(sli, objective)
+
+			burnRates := make(map[string]float64)
+			for i, w := range l.config.BurnRateWindows {
+				long := r.sum(index, l.buckets(w.Long))
+				short := r.sum(index, l.buckets(w.Short))
+				longRate := long.burnRate(sli, objective)
+				shortRate := short.burnRate(sli, objective)
+				burnRates[w.Long.String()] = longRate
+				burnRates[w.Short.String()] = shortRate
+				l.checkAlert(route, r, sli, i, w, longRate, shortRate)
+			}
+			kv[sli+"-burn-rates"] = burnRates
+		}
+		summary[route] = kv
+	}
+	et.Set(KeySLORoutes, summary)
+}
+
+// checkAlert sends alert event when multi window condition starts
+// or stops firing, caller holds l.mu
+func (l *SLOListener) checkAlert(route string, r *routeSLO, sli string, i int, w BurnRateWindow, longRate, shortRate float64) {
+	key := sloAlertKey{sli, i}
+	firing := longRate >= w.Threshold && shortRate >= w.Threshold
+	if firing == r.alerts[key] {
+		return
+	}
+	r.alerts[key] = firing
+	sendAlert(route, sli, w, firing, longRate, shortRate)
+}
+
+// resolveAlerts stops firing alerts of a route which is not tracked
+// anymore, otherwise they would stay firing forever, caller holds l.mu
+func (l *SLOListener) resolveAlerts(route string, r *routeSLO) {
+	for key, firing := range r.alerts {
+		if firing {
+			sendAlert(route, key.sli, l.config.BurnRateWindows[key.window], false, 0, 0)
+		}
+	}
+}
+
+func sendAlert(route, sli string, w BurnRateWindow, firing bool, longRate, shortRate float64) {
+	et := gomon.FromContext(nil).NewChild(false)
+	et.SetFingerprint("slo-alert")
+	et.Set(KeySLORoute, route)
+	et.Set(KeySLOIndicator, sli)
+	et.Set(KeySLOFiring, firing)
+	et.Set(KeySLOThreshold, w.Threshold)
+	et.Set(KeySLOLongWindow, w.Long)
+	et.Set(KeySLOShortWindow, w.Short)
+	et.Set(KeySLOLongBurnRate, longRate)
+	et.Set(KeySLOShortBurnRate, shortRate)
+	et.Finish()
+}
@@ -0,0 +1,129 @@
+package listener
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/iahmedov/gomon"
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+func TestSLOBucketRotation(t *testing.T) {
+	r := &routeSLO{
+		objectives: SLOObjectives{ApdexThreshold: time.Second},
+		buckets:    make([]sloBucket, 3),
+	}
+	r.add(10, 200, 0)
+	r.add(11, 500, 0)
+	r.add(12, 200, 5*time.Second)
+	r.add(12, 200, 2*time.Second)
+
+	if s := r.sum(12, 3); s.total != 4 || s.errors != 1 || s.satisfied != 2 || s.tolerating != 1 {
+		t.Errorf("sum of 3 buckets = %+v", s)
+	}
+	if s := r.sum(12, 1); s.total != 2 {
+		t.Errorf("sum of the last bucket = %+v, want 2 requests", s)
+	}
+
+	// bucket of index 10 is reused
+	r.add(13, 200, 0)
+	if s := r.sum(13, 3); s.total != 4 || s.errors != 1 {
+		t.Errorf("sum after rotation = %+v, want 4 requests, 1 error", s)
+	}
+	if s := r.sum(13, 10); s.total != 4 {
+		t.Errorf("sum is not limited to window: %+v", s)
+	}
+
+	// buckets older than window are not counted even if not overwritten
+	if s := r.sum(20, 3); s.total != 0 {
+		t.Errorf("sum of stale buckets = %+v, want empty", s)
+	}
+}
+
+func TestSLOBucketIndicators(t *testing.T) {
+	b := sloBucket{total: 100, errors: 2, slow: 5, satisfied: 80, tolerating: 10}
+	if apdex := b.apdex(); apdex != 0.85 {
+		t.Errorf("apdex = %v, want 0.85", apdex)
+	}
+	if c := b.compliance(sliLatency); c != 0.95 {
+		t.Errorf("latency compliance = %v, want 0.95", c)
+	}
+	if rate := b.burnRate(sliAvailability, 0.99); rate < 1.99 || rate > 2.01 {
+		t.Errorf("burn rate = %v, want 2", rate)
+	}
+	if rate := (&sloBucket{}).burnRate(sliAvailability, 0.99); rate != 0 {
+		t.Errorf("burn rate without requests = %v, want 0", rate)
+	}
+}
+
+func sloEvent(route string, code interface{}) gomon.EventTracker {
+	et := gomon.FromContext(nil).NewChild(false)
+	et.Set(gomonhttp.KeyDirection, "incoming")
+	et.Set(gomonhttp.KeyRoute, route)
+	if code != nil {
+		et.Set(gomonhttp.KeyResponseCode, code)
+	}
+	return et
+}
+
+func TestSLOListenerRoutes(t *testing.T) {
+	l := NewSLOListener(&SLOConfig{
+		Routes:    map[string]SLOObjectives{"/configured": {}},
+		Window:    time.Hour,
+		MaxRoutes: 2,
+	}).(*SLOListener)
+	defer l.Stop()
+
+	for i := 0; i < 5; i++ {
+		l.Feed(sloEvent("/r"+strconv.Itoa(i), 200))
+	}
+	l.Feed(sloEvent("/configured", 200))
+	// response code is not recorded
+	l.Feed(sloEvent("/r0", nil))
+
+	want := map[string]int64{"/r0": 1, "/r1": 1, KeySLOOtherRoutes: 3, "/configured": 1}
+	if len(l.routes) != len(want) {
+		t.Errorf("routes = %v, want %v", l.routes, want)
+	}
+	index := l.bucketIndex(time.Now())
+	for route, n := range want {
+		r, ok := l.routes[route]
+		if !ok {
+			t.Errorf("route %s is not tracked", route)
+			continue
+		}
+		if s := r.sum(index, len(r.buckets)); s.total != n {
+			t.Errorf("route %s has %d requests, want %d", route, s.total, n)
+		}
+	}
+}
+
+func TestSLOIdleRouteResolvesAlerts(t *testing.T) {
+	events.reset()
+	l := NewSLOListener(&SLOConfig{
+		SLOObjectives:   SLOObjectives{AvailabilityObjective: 0.9},
+		Window:          time.Minute,
+		BurnRateWindows: []BurnRateWindow{{Long: time.Minute, Short: time.Minute, Threshold: 1}},
+	}).(*SLOListener)
+	defer l.Stop()
+
+	l.Feed(sloEvent("/failing", 500))
+	now := time.Now()
+	l.report(now)
+	// route has no requests during the window
+	l.report(now.Add(2 * time.Minute))
+	if _, ok := l.routes["/failing"]; ok {
+		t.Error("idle route is tracked")
+	}
+
+	firing := map[bool]int{}
+	for _, et := range events.wait(t, "slo-alert", 2) {
+		if et.Get(KeySLORoute) == "/failing" && et.Get(KeySLOIndicator) == sliAvailability {
+			firing[et.Get(KeySLOFiring).(bool)]++
+		}
+	}
+	if firing[true] != 1 || firing[false] != 1 {
+		t.Errorf("alerts firing/resolved = %d/%d, want 1/1", firing[true], firing[false])
+	}
+}
@@ -0,0 +1,80 @@
+package net
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+func init() {
+	gomon.SetConfigFunc(pluginName, SetConfig)
+}
+
+type PluginConfig struct {
+	// interval of summary events of long-lived packet conns,
+	// zero disables them (summary is still sent on Close)
+	PacketSummaryInterval time.Duration
+	// peers tracked separately by packet conn,
+	// traffic of the rest is counted as KeyOtherPeers
+	PacketMaxPeers int
+
+	// first bytes of each direction of a connection kept
+	// for events, zero disables sampling
+	SampleSize int
+	// bytes kept from every read/write until SampleOpBudget
+	// bytes are used, per direction
+	SampleOpSize   int
+	SampleOpBudget int
+	// applied to samples before they are sent to listeners
+	Redactors []Redactor
+
+	// event is sent when read/write is blocked longer than
+	// threshold, zero disables it. Every read/write arms a timer
+	// when enabled. Reads of idle keep-alive connections block
+	// as well, so read threshold should be higher than idle timeout
+	ReadStallThreshold  time.Duration
+	WriteStallThreshold time.Duration
+
+	// interval of snapshot events of monitored listeners,
+	// zero disables them (snapshot is still sent on Close)
+	ListenerStatInterval time.Duration
+
+	// interval of certificate expiry reports of
+	// MonitoredTLSListener, zero reports only once
+	TLSCertReportInterval time.Duration
+
+	// detects protocol (http1, http2, tls, redis, postgres, mysql)
+	// from the first bytes of connections
+	SniffProtocol bool
+}
+
+// periodic reports are opt-in, each of them runs a goroutine
+var defaultConfig = &PluginConfig{
+	PacketMaxPeers: 256,
+}
+
+// config set by SetConfig, it is loaded once per
+// connection, listener or packet conn
+var currentConfig atomic.Value // *PluginConfig
+
+var pluginName = "gomon/net"
+
+func SetConfig(c gomon.TrackerConfig) {
+	if conf, ok := c.(*PluginConfig); ok {
+		currentConfig.Store(conf)
+	} else {
+		panic("not compatible config")
+	}
+}
+
+func loadConfig() *PluginConfig {
+	if conf, ok := currentConfig.Load().(*PluginConfig); ok {
+		return conf
+	}
+	return defaultConfig
+}
+
+func (p *PluginConfig) Name() string {
+	return pluginName
+}
@@ -2,39 +2,78 @@ package net
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/iahmedov/gomon"
 )
 
-// TODO: implement net/textproto.Conn
-// TODO: implement net.PacketConn
-
 type wrappedNetConn struct {
-	parent              net.Conn
+	parent net.Conn
+	// Read, Write and Close can be called concurrently, writes
+	// to et go through mu
+	mu                  sync.Mutex
 	et                  gomon.EventTracker
 	readSize, writeSize int64
 
-	// not implemented yet
-	// used for storing sample data from read,write bytes
-	readSample, writeSample []byte
+	// time when connection was wrapped (accepted/dialed)
+	// and number of http requests served over it
+	created  time.Time
+	requests int64
+
+	// nil if sampling is disabled
+	readSample, writeSample *payloadSampler
+	redactors               []Redactor
+
+	reads, writes *connOps
+	// unix nanoseconds
+	lastTransfer int64
+	maxIdle      int64
+	closed       int32
+
+	// set if accepted by MonitoredListener
+	listener *wrappedListener
+	// set if accepted by MonitoredTLSListener
+	handshake *tlsHandshake
+	// nil if sniffing is disabled
+	sniffer *protocolSniffer
 }
 
 type promoteToPacketConn struct {
 	*wrappedNetConn
+
+	mu   sync.Mutex
+	stat *packetStat
 }
 
+type connContextKey struct{}
+
 var _ net.Conn = (*wrappedNetConn)(nil)
 var _ net.PacketConn = (*promoteToPacketConn)(nil)
 
 func MonitoredConn(c net.Conn, ctx context.Context) net.Conn {
 	et := gomon.FromContext(ctx).NewChild(false)
+	config := loadConfig()
+	now := time.Now()
 	wnc := &wrappedNetConn{
 		parent:    c,
 		et:        et,
 		readSize:  0,
 		writeSize: 0,
+		created:   now,
+
+		readSample:  newPayloadSampler(config),
+		writeSample: newPayloadSampler(config),
+		redactors:   config.Redactors,
+
+		reads:        newConnOps("read", config.ReadStallThreshold),
+		writes:       newConnOps("write", config.WriteStallThreshold),
+		lastTransfer: now.UnixNano(),
+
+		sniffer: newProtocolSniffer(config),
 	}
 
 	// fills `et` if addrs are available
@@ -42,47 +81,162 @@ func MonitoredConn(c net.Conn, ctx context.Context) net.Conn {
 	wnc.RemoteAddr()
 
 	if _, ok := c.(net.PacketConn); ok {
-		return &promoteToPacketConn{wnc}
+		return &promoteToPacketConn{
+			wrappedNetConn: wnc,
+			stat:           newPacketStat(config.PacketMaxPeers),
+		}
 	} else {
 		return wnc
 	}
 }
 
+// ConnContext can be used as http.Server.ConnContext, it makes
+// connections accepted by MonitoredListener visible to handlers
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		// http.Server.ServeTLS wraps accepted connections
+		c = tc.NetConn()
+	}
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// ConnAge returns time passed since connection of the request was
+// accepted and number of requests started on it (see StartConnRequest).
+// ok is false if context has no connection from MonitoredListener
+func ConnAge(ctx context.Context) (sinceAccept time.Duration, requests int64, ok bool) {
+	wnc := connFromContext(ctx)
+	if wnc == nil {
+		return 0, 0, false
+	}
+
+	return time.Since(wnc.created), atomic.LoadInt64(&wnc.requests), true
+}
+
+// StartConnRequest marks start of a request on connection of ctx and
+// returns its number on the connection, it must be called once per request
+func StartConnRequest(ctx context.Context) (nth int64, ok bool) {
+	wnc := connFromContext(ctx)
+	if wnc == nil {
+		return 0, false
+	}
+
+	return atomic.AddInt64(&wnc.requests, 1), true
+}
+
+func connFromContext(ctx context.Context) *wrappedNetConn {
+	c, _ := ctx.Value(connContextKey{}).(net.Conn)
+	return unwrapNetConn(c)
+}
+
+// nil if c is not monitored
+func unwrapNetConn(c net.Conn) *wrappedNetConn {
+	switch c := c.(type) {
+	case *wrappedNetConn:
+		return c
+	case *promoteToPacketConn:
+		return c.wrappedNetConn
+	}
+	return nil
+}
+
 func (w *wrappedNetConn) Read(b []byte) (n int, err error) {
+	op := w.startOp(w.reads, len(b))
 	defer func() {
-		w.readSize += int64(n)
-		if err != nil {
-			w.et.AddError(err)
+		atomic.AddInt64(&w.readSize, int64(n))
+		w.readSample.observe(b[:n])
+		if w.handshake != nil {
+			w.handshake.observe(true, b[:n])
+		}
+		w.sniff(true, b[:n])
+		if timeout := op.done(n, err); err != nil && !timeout {
+			w.addError(err)
 		}
 	}()
 	return w.parent.Read(b)
 }
 
 func (w *wrappedNetConn) Write(b []byte) (n int, err error) {
+	op := w.startOp(w.writes, len(b))
 	defer func() {
-		w.writeSize += int64(n)
-		if err != nil {
-			w.et.AddError(err)
+		atomic.AddInt64(&w.writeSize, int64(n))
+		w.writeSample.observe(b[:n])
+		if w.handshake != nil {
+			w.handshake.observe(false, b[:n])
+		}
+		w.sniff(false, b[:n])
+		if timeout := op.done(n, err); err != nil && !timeout {
+			w.addError(err)
 		}
 	}()
 	return w.parent.Write(b)
 }
 
+func (w *wrappedNetConn) sniff(remote bool, b []byte) {
+	if w.sniffer == nil {
+		return
+	}
+	if protocol, ok := w.sniffer.observe(remote, b); ok {
+		w.protocolDetected(protocol)
+	}
+}
+
+func (w *wrappedNetConn) protocolDetected(protocol string) {
+	w.set(KeyProtocol, protocol)
+	if w.listener != nil {
+		w.listener.protocolDetected(protocol)
+	}
+}
+
 func (w *wrappedNetConn) Close() (err error) {
+	if atomic.SwapInt32(&w.closed, 1) == 0 && w.listener != nil {
+		defer w.listener.connClosed(w)
+	}
 	defer func() {
+		if w.sniffer != nil {
+			if protocol, ok := w.sniffer.closed(); ok {
+				w.protocolDetected(protocol)
+			}
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
 		if err != nil {
 			w.et.AddError(err)
 		}
+		w.fillOps(time.Now())
+		if w.handshake != nil {
+			w.handshake.closed(w.et, atomic.LoadInt64(&w.readSize))
+		}
+		if tc, ok := w.parent.(*tls.Conn); ok {
+			// accepted from tls.Listener
+			if cs := tc.ConnectionState(); cs.HandshakeComplete {
+				fillTLSState(w.et, cs)
+			}
+		}
+		w.readSample.fill(w.et, "read", KeyReadSample, KeyReadOpSamples, w.redactors)
+		w.writeSample.fill(w.et, "write", KeyWriteSample, KeyWriteOpSamples, w.redactors)
 		w.et.Finish()
 	}()
 	return w.parent.Close()
 }
 
+func (w *wrappedNetConn) addError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.et.AddError(err)
+}
+
+func (w *wrappedNetConn) set(key string, value interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.et.Set(key, value)
+}
+
 func (w *wrappedNetConn) LocalAddr() (laddr net.Addr) {
 	defer func() {
 		if laddr != nil {
-			w.et.Set("laddr", laddr.String())
-			w.et.Set("laddr-net", laddr.Network())
+			w.set("laddr", laddr.String())
+			w.set("laddr-net", laddr.Network())
 		}
 	}()
 	return w.parent.LocalAddr()
@@ -91,8 +245,8 @@ func (w *wrappedNetConn) LocalAddr() (laddr net.Addr) {
 func (w *wrappedNetConn) RemoteAddr() (raddr net.Addr) {
 	defer func() {
 		if raddr != nil {
-			w.et.Set("raddr", raddr.String())
-			w.et.Set("raddr-net", raddr.Network())
+			w.set("raddr", raddr.String())
+			w.set("raddr-net", raddr.Network())
 		}
 	}()
 	return w.parent.RemoteAddr()
@@ -101,7 +255,7 @@ func (w *wrappedNetConn) RemoteAddr() (raddr net.Addr) {
 func (w *wrappedNetConn) SetDeadline(t time.Time) (err error) {
 	defer func() {
 		if err != nil {
-			w.et.AddError(err)
+			w.addError(err)
 		}
 	}()
 	return w.parent.SetDeadline(t)
@@ -110,7 +264,7 @@ func (w *wrappedNetConn) SetDeadline(t time.Time) (err error) {
 func (w *wrappedNetConn) SetReadDeadline(t time.Time) (err error) {
 	defer func() {
 		if err != nil {
-			w.et.AddError(err)
+			w.addError(err)
 		}
 	}()
 	return w.parent.SetReadDeadline(t)
@@ -119,38 +273,22 @@ func (w *wrappedNetConn) SetReadDeadline(t time.Time) (err error) {
 func (w *wrappedNetConn) SetWriteDeadline(t time.Time) (err error) {
 	defer func() {
 		if err != nil {
-			w.et.AddError(err)
+			w.addError(err)
 		}
 	}()
 	return w.parent.SetWriteDeadline(t)
 }
 
-func (c *promoteToPacketConn) addIP(key string, addr net.Addr) {
-	et := c.wrappedNetConn.et
-	var ips []net.Addr
-	v := et.Get(key)
-	if v != nil {
-		var ok = false
-		ips, ok = v.([]net.Addr)
-		if !ok {
-			ips = make([]net.Addr, 0, 1)
-		}
-	} else {
-		ips = make([]net.Addr, 0, 1)
-	}
-
-	ips = append(ips, addr)
-	et.Set(key, ips)
-}
-
 func (c *promoteToPacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
 	pconn, _ := c.wrappedNetConn.parent.(net.PacketConn)
 	defer func() {
 		if err != nil {
-			c.wrappedNetConn.et.AddError(err)
+			c.wrappedNetConn.addError(err)
 		}
-		c.wrappedNetConn.readSize += int64(n)
-		c.addIP("read-ip", addr)
+		atomic.AddInt64(&c.wrappedNetConn.readSize, int64(n))
+		c.mu.Lock()
+		c.stat.read(addr, n, len(b) > 0 && n == len(b))
+		c.mu.Unlock()
 	}()
 	return pconn.ReadFrom(b)
 }
@@ -159,10 +297,21 @@ func (c *promoteToPacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error
 	pconn, _ := c.wrappedNetConn.parent.(net.PacketConn)
 	defer func() {
 		if err != nil {
-			c.wrappedNetConn.et.AddError(err)
+			c.wrappedNetConn.addError(err)
 		}
-		c.wrappedNetConn.writeSize += int64(n)
-		c.addIP("write-ip", addr)
+		atomic.AddInt64(&c.wrappedNetConn.writeSize, int64(n))
+		c.mu.Lock()
+		c.stat.write(addr, n)
+		c.mu.Unlock()
 	}()
 	return pconn.WriteTo(b, addr)
 }
+
+func (c *promoteToPacketConn) Close() (err error) {
+	c.mu.Lock()
+	c.wrappedNetConn.mu.Lock()
+	c.stat.fillPeers(c.wrappedNetConn.et)
+	c.wrappedNetConn.mu.Unlock()
+	c.mu.Unlock()
+	return c.wrappedNetConn.Close()
+}
@@ -0,0 +1,239 @@
+package net
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// Dialer is net.Dialer which resolves host with monitored Resolver and
+// tracks every connect attempt, IPv4/IPv6 addresses are raced the same
+// way as net.Dialer does (happy eyeballs). Connections are monitored
+type Dialer struct {
+	net.Dialer
+	// lookups are done by net.Dialer.Resolver if nil
+	Lookup *Resolver
+}
+
+type dialResult struct {
+	net.Conn
+	error
+	primary bool
+	done    bool
+}
+
+var (
+	KeyNetwork   = "network"
+	KeyAddress   = "address"
+	KeyAttempts  = "attempts"
+	KeyCancelled = "cancelled"
+	KeyConnected = "connected"
+)
+
+// same as net.Dialer
+const defaultFallbackDelay = 300 * time.Millisecond
+
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (c net.Conn, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	et := gomon.FromContext(ctx).NewChild(false)
+	et.SetFingerprint("net-dial")
+	et.Set(KeyNetwork, network)
+	et.Set(KeyAddress, address)
+	defer et.Finish()
+	ctx = gomon.WithContext(ctx, et)
+
+	if deadline := d.deadline(time.Now()); !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	c, err = d.dial(ctx, et, network, address)
+	if err != nil {
+		et.AddError(err)
+		return nil, err
+	}
+	et.Set(KeyConnected, c.RemoteAddr().String())
+	return MonitoredConn(c, ctx), nil
+}
+
+func (d *Dialer) dial(ctx context.Context, et gomon.EventTracker, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		// unix sockets etc.
+		return d.attempt(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || len(host) == 0 || net.ParseIP(host) != nil {
+		return d.attempt(ctx, network, address)
+	}
+
+	lookup := d.Lookup
+	if lookup == nil {
+		lookup = &Resolver{Resolver: d.Resolver}
+	}
+	ips, err := lookup.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, ip := range ips {
+		if familyMatches(network, ip.IP) {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	et.Set(KeyAttempts, len(addrs))
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+
+	primaries, fallbacks := partitionAddrs(addrs)
+	if len(fallbacks) == 0 || d.FallbackDelay < 0 {
+		return d.dialSerial(ctx, network, addrs)
+	}
+	return d.dialParallel(ctx, network, primaries, fallbacks)
+}
+
+// dialParallel starts fallbacks (other address family) if
+// primaries did not connect during fallback delay
+func (d *Dialer) dialParallel(ctx context.Context, network string, primaries, fallbacks []string) (net.Conn, error) {
+	returned := make(chan struct{})
+	defer close(returned)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	race := func(addrs []string, primary bool) {
+		c, err := d.dialSerial(ctx, network, addrs)
+		select {
+		case results <- dialResult{Conn: c, error: err, primary: primary, done: true}:
+		case <-returned:
+			if c != nil {
+				c.Close()
+			}
+		}
+	}
+
+	delay := d.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	fallbackTimer := time.NewTimer(delay)
+	defer fallbackTimer.Stop()
+
+	go race(primaries, true)
+	var primary, fallback dialResult
+	for {
+		select {
+		case <-fallbackTimer.C:
+			go race(fallbacks, false)
+		case res := <-results:
+			if res.error == nil {
+				return res.Conn, nil
+			}
+			if res.primary {
+				primary = res
+			} else {
+				fallback = res
+			}
+			if primary.done && fallback.done {
+				return nil, primary.error
+			}
+			if res.primary && fallbackTimer.Stop() {
+				// primaries failed, start fallbacks right away
+				fallbackTimer.Reset(0)
+			}
+		}
+	}
+}
+
+func (d *Dialer) dialSerial(ctx context.Context, network string, addrs []string) (c net.Conn, err error) {
+	var firstErr error
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			break
+		}
+		c, err = d.attempt(ctx, network, addr)
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return nil, firstErr
+}
+
+// attempt connects to a single address, name resolution and
+// deadline were already handled by DialContext
+func (d *Dialer) attempt(ctx context.Context, network, address string) (c net.Conn, err error) {
+	et := gomon.FromContext(ctx).NewChild(false)
+	et.SetFingerprint("net-dial-attempt")
+	et.Set(KeyAddress, address)
+	defer et.Finish()
+
+	dialer := d.Dialer
+	dialer.Timeout = 0
+	dialer.Deadline = time.Time{}
+	c, err = dialer.DialContext(ctx, network, address)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			// other address family won the race
+			et.Set(KeyCancelled, true)
+		} else {
+			et.AddError(err)
+		}
+	}
+	return
+}
+
+func (d *Dialer) deadline(now time.Time) (deadline time.Time) {
+	if d.Timeout > 0 {
+		deadline = now.Add(d.Timeout)
+	}
+	if !d.Deadline.IsZero() && (deadline.IsZero() || d.Deadline.Before(deadline)) {
+		deadline = d.Deadline
+	}
+	return
+}
+
+func familyMatches(network string, ip net.IP) bool {
+	switch network[len(network)-1] {
+	case '4':
+		return ip.To4() != nil
+	case '6':
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// partitionAddrs splits addresses by family of the first one
+func partitionAddrs(addrs []string) (primaries, fallbacks []string) {
+	isV4 := func(addr string) bool {
+		host, _, _ := net.SplitHostPort(addr)
+		return net.ParseIP(host).To4() != nil
+	}
+	first := isV4(addrs[0])
+	for _, addr := range addrs {
+		if isV4(addr) == first {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return
+}
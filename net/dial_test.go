@@ -0,0 +1,118 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+func TestDialerAttempts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	root := gomon.FromContext(nil).NewChild(false)
+	ctx := gomon.WithContext(context.Background(), root)
+	d := &Dialer{Lookup: &Resolver{CacheTTL: time.Minute}}
+	for i := 0; i < 2; i++ {
+		c, err := d.DialContext(ctx, "tcp4", net.JoinHostPort("localhost", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.(*wrappedNetConn); !ok {
+			t.Errorf("dialed conn %T is not monitored", c)
+		}
+		c.Close()
+	}
+
+	dials := events.wait(t, "net-dial", 2, childOf(root))
+	for _, dial := range dials {
+		if dial.Get(KeyConnected) != l.Addr().String() || dial.Get(KeyAttempts) != 1 {
+			t.Errorf("dial connected to %v with %v attempts", dial.Get(KeyConnected), dial.Get(KeyAttempts))
+		}
+		attempt := events.wait(t, "net-dial-attempt", 1, childOf(dial))[0]
+		if attempt.Get(KeyAddress) != l.Addr().String() {
+			t.Errorf("attempt address = %v", attempt.Get(KeyAddress))
+		}
+	}
+
+	hits := map[interface{}]int{}
+	for _, dial := range dials {
+		lookup := events.wait(t, "net-dns", 1, childOf(dial))[0]
+		if lookup.Get(KeyHost) != "localhost" {
+			t.Errorf("lookup host = %v", lookup.Get(KeyHost))
+		}
+		hits[lookup.Get(KeyCacheHit)]++
+	}
+	// second dial is served from cache, events are fed in any order
+	if hits[true] != 1 || hits[false] != 1 {
+		t.Errorf("cache hits = %v, want one miss and one hit", hits)
+	}
+}
+
+func TestDialerError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	root := gomon.FromContext(nil).NewChild(false)
+	ctx := gomon.WithContext(context.Background(), root)
+	d := &Dialer{}
+	if _, err := d.DialContext(ctx, "tcp", addr); err == nil {
+		t.Fatal("dial to closed port succeeded")
+	}
+
+	dial := events.wait(t, "net-dial", 1, childOf(root))[0]
+	if errs, _ := dial.Get(gomon.KeyErrors).([]error); len(errs) != 1 {
+		t.Errorf("dial errors = %v", errs)
+	}
+	if dial.Get(KeyConnected) != nil {
+		t.Errorf("failed dial connected to %v", dial.Get(KeyConnected))
+	}
+	attempt := events.wait(t, "net-dial-attempt", 1, childOf(dial))[0]
+	if errs, _ := attempt.Get(gomon.KeyErrors).([]error); len(errs) != 1 {
+		t.Errorf("attempt errors = %v", errs)
+	}
+}
+
+func TestLookupErrorKinds(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind interface{}
+	}{
+		{&net.DNSError{IsNotFound: true}, "not-found"},
+		{&net.DNSError{IsTimeout: true}, "timeout"},
+		{&net.DNSError{IsTemporary: true}, "temporary"},
+		{&net.DNSError{}, "other"},
+		{errors.New("not a dns error"), nil},
+	}
+	for _, c := range cases {
+		et := gomon.FromContext(nil).NewChild(false)
+		lookupError(et, c.err)
+		if et.Get(KeyDNSError) != c.kind {
+			t.Errorf("%v: kind = %v, want %v", c.err, et.Get(KeyDNSError), c.kind)
+		}
+		if errs, _ := et.Get(gomon.KeyErrors).([]error); len(errs) != 1 {
+			t.Errorf("%v: errors = %v", c.err, errs)
+		}
+	}
+}
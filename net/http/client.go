@@ -0,0 +1,67 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+// DefaultTransport is a copy of http.DefaultTransport with monitored
+// connections, it is created on first use so that it follows config
+// set by gomon.SetConfig and gomon/http.AutoRegister. It is not
+// *http.Transport, unlike http.DefaultTransport
+var DefaultTransport RoundTripper = &lazyTransport{}
+
+// DefaultClient is used by Get, Head, Post and PostForm
+var DefaultClient = gomonhttp.MonitoredClient(&http.Client{Transport: DefaultTransport})
+
+type lazyTransport struct {
+	once sync.Once
+	rt   RoundTripper
+}
+
+func (t *lazyTransport) transport() RoundTripper {
+	t.once.Do(func() {
+		switch rt := http.DefaultTransport.(type) {
+		case interface{ Unwrap() http.RoundTripper }:
+			// replaced by gomon/http.AutoRegister, wrapped transport
+			// is monitored already, requests are monitored by clients
+			t.rt = rt.Unwrap()
+		case *http.Transport:
+			t.rt = gomonhttp.MonitoredNamedTransport("net/http", rt.Clone())
+		default:
+			t.rt = rt
+		}
+	})
+	return t.rt
+}
+
+func (t *lazyTransport) RoundTrip(r *Request) (*Response, error) {
+	return t.transport().RoundTrip(r)
+}
+
+// CloseIdleConnections is used by http.Client.CloseIdleConnections
+func (t *lazyTransport) CloseIdleConnections() {
+	if c, ok := t.transport().(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func Get(url string) (resp *Response, err error) {
+	return DefaultClient.Get(url)
+}
+
+func Head(url string) (resp *Response, err error) {
+	return DefaultClient.Head(url)
+}
+
+func Post(url, contentType string, body io.Reader) (resp *Response, err error) {
+	return DefaultClient.Post(url, contentType, body)
+}
+
+func PostForm(url string, data url.Values) (resp *Response, err error) {
+	return DefaultClient.PostForm(url, data)
+}
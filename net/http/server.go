@@ -0,0 +1,233 @@
+// Package http mirrors server and client API of net/http, everything
+// served or requested through it is monitored: listeners are wrapped with
+// gomon/net.MonitoredListener, handlers with gomon/http.MonitoringHandler
+// and clients with gomon/http.MonitoredClient. Migrating a service is
+// usually just replacing "net/http" import with this package.
+//
+// Unlike net/http, DefaultTransport is not *http.Transport, code which
+// asserts its type (e.g. to Clone it) has to use http.DefaultTransport
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	gomonhttp "github.com/iahmedov/gomon/http"
+	gomonnet "github.com/iahmedov/gomon/net"
+)
+
+// ServeMux is http.ServeMux which monitors requests it serves
+type ServeMux struct {
+	once      sync.Once
+	mux       *http.ServeMux
+	monitored http.Handler
+}
+
+// Server has the same fields as http.Server, fields should not
+// be changed after server is started
+type Server struct {
+	Addr              string
+	Handler           Handler
+	TLSConfig         *tls.Config
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	TLSNextProto      map[string]func(*http.Server, *tls.Conn, Handler)
+	ConnState         func(net.Conn, ConnState)
+	ErrorLog          *log.Logger
+	BaseContext       func(net.Listener) context.Context
+	ConnContext       func(ctx context.Context, c net.Conn) context.Context
+
+	mu         sync.Mutex
+	srv        *http.Server
+	onShutdown []func()
+	keepAlives *bool
+}
+
+// DefaultServeMux shares registered handlers with http.DefaultServeMux
+var DefaultServeMux = &ServeMux{mux: http.DefaultServeMux}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{mux: http.NewServeMux()}
+}
+
+func (m *ServeMux) init() {
+	m.once.Do(func() {
+		if m.mux == nil {
+			m.mux = http.NewServeMux()
+		}
+		m.monitored = gomonhttp.NewMonitoringHandler(m.mux)
+	})
+}
+
+func (m *ServeMux) Handle(pattern string, handler Handler) {
+	m.init()
+	m.mux.Handle(pattern, handler)
+}
+
+func (m *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	m.init()
+	m.mux.HandleFunc(pattern, handler)
+}
+
+func (m *ServeMux) Handler(r *Request) (h Handler, pattern string) {
+	m.init()
+	return m.mux.Handler(r)
+}
+
+func (m *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
+	m.init()
+	m.monitored.ServeHTTP(w, r)
+}
+
+func Handle(pattern string, handler Handler) {
+	DefaultServeMux.Handle(pattern, handler)
+}
+
+func HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	DefaultServeMux.HandleFunc(pattern, handler)
+}
+
+func ListenAndServe(addr string, handler Handler) error {
+	server := &Server{Addr: addr, Handler: handler}
+	return server.ListenAndServe()
+}
+
+func ListenAndServeTLS(addr, certFile, keyFile string, handler Handler) error {
+	server := &Server{Addr: addr, Handler: handler}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+func Serve(l net.Listener, handler Handler) error {
+	server := &Server{Handler: handler}
+	return server.Serve(l)
+}
+
+func ServeTLS(l net.Listener, handler Handler, certFile, keyFile string) error {
+	server := &Server{Handler: handler}
+	return server.ServeTLS(l, certFile, keyFile)
+}
+
+// server creates underlying http.Server on first call
+func (s *Server) server() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv != nil {
+		return s.srv
+	}
+
+	s.srv = &http.Server{
+		Addr:              s.Addr,
+		Handler:           monitoredHandler(s.Handler),
+		TLSConfig:         s.TLSConfig,
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+		TLSNextProto:      s.TLSNextProto,
+		ConnState:         s.ConnState,
+		ErrorLog:          s.ErrorLog,
+		BaseContext:       s.BaseContext,
+		ConnContext:       connContext(s.ConnContext),
+	}
+	for _, f := range s.onShutdown {
+		s.srv.RegisterOnShutdown(f)
+	}
+	if s.keepAlives != nil {
+		s.srv.SetKeepAlivesEnabled(*s.keepAlives)
+	}
+	return s.srv
+}
+
+func monitoredHandler(handler Handler) Handler {
+	switch h := handler.(type) {
+	case nil:
+		return DefaultServeMux
+	case *ServeMux:
+		// already monitored
+		return h
+	}
+	if gomonhttp.IsMonitoringHandler(handler) {
+		return handler
+	}
+	return gomonhttp.NewMonitoringHandler(handler)
+}
+
+// connContext makes accepted connections visible to request trackers
+func connContext(f func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		ctx = gomonnet.ConnContext(ctx, c)
+		if f != nil {
+			ctx = f(ctx, c)
+		}
+		return ctx
+	}
+}
+
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if len(addr) == 0 {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := s.Addr
+	if len(addr) == 0 {
+		addr = ":https"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(l, certFile, keyFile)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	return s.server().Serve(gomonnet.MonitoredListener(l))
+}
+
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	return s.server().ServeTLS(gomonnet.MonitoredListener(l), certFile, keyFile)
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server().Shutdown(ctx)
+}
+
+func (s *Server) Close() error {
+	return s.server().Close()
+}
+
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv != nil {
+		s.srv.RegisterOnShutdown(f)
+		return
+	}
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+func (s *Server) SetKeepAlivesEnabled(v bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv != nil {
+		s.srv.SetKeepAlivesEnabled(v)
+		return
+	}
+	s.keepAlives = &v
+}
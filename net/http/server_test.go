@@ -0,0 +1,91 @@
+package http
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	gomonhttp "github.com/iahmedov/gomon/http"
+)
+
+func serve(t *testing.T, handler Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: handler}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + l.Addr().String()
+}
+
+func getBody(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestServeAndGet(t *testing.T) {
+	events.reset()
+	url := serve(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	if body := getBody(t, url+"/a"); body != "ok" {
+		t.Errorf("body = %q, want ok", body)
+	}
+	incoming := events.wait(t, "http-wmux-servehttp", 1)[0]
+	if code := incoming.Get(gomonhttp.KeyResponseCode); code != 200 {
+		t.Errorf("incoming response code = %v, want 200", code)
+	}
+	events.wait(t, "http-roundtripper", 1)
+	events.wait(t, "http-client", 1)
+}
+
+func TestServeMonitoringHandler(t *testing.T) {
+	events.reset()
+	handler := gomonhttp.NewMonitoringHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}))
+	if monitoredHandler(handler) != handler {
+		t.Error("monitoring handler is wrapped again")
+	}
+
+	url := serve(t, handler)
+	getBody(t, url)
+	events.wait(t, "http-wmux-servehttp", 1)
+	// requests are fed asynchronously
+	time.Sleep(50 * time.Millisecond)
+	if n := len(events.wait(t, "http-wmux-servehttp", 1)); n != 1 {
+		t.Errorf("request is tracked %d times, want 1", n)
+	}
+}
+
+// DefaultServeMux panics on registering the same pattern twice (-count)
+var registerDefault sync.Once
+
+func TestServeDefaultServeMux(t *testing.T) {
+	events.reset()
+	registerDefault.Do(func() {
+		HandleFunc("/default", func(w ResponseWriter, r *Request) {
+			io.WriteString(w, "default")
+		})
+	})
+
+	url := serve(t, nil)
+	if body := getBody(t, url+"/default"); body != "default" {
+		t.Errorf("body = %q, want default", body)
+	}
+	events.wait(t, "http-wmux-servehttp", 1)
+}
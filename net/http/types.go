@@ -0,0 +1,159 @@
+package http
+
+import (
+	"net/http"
+)
+
+// types, functions and constants of net/http which need no monitoring,
+// they are the same as in net/http
+
+type (
+	Client         = http.Client
+	ConnState      = http.ConnState
+	Cookie         = http.Cookie
+	CookieJar      = http.CookieJar
+	Dir            = http.Dir
+	File           = http.File
+	FileSystem     = http.FileSystem
+	Flusher        = http.Flusher
+	Handler        = http.Handler
+	HandlerFunc    = http.HandlerFunc
+	Header         = http.Header
+	Hijacker       = http.Hijacker
+	Pusher         = http.Pusher
+	Request        = http.Request
+	Response       = http.Response
+	ResponseWriter = http.ResponseWriter
+	RoundTripper   = http.RoundTripper
+	SameSite       = http.SameSite
+	Transport      = http.Transport
+)
+
+const (
+	MethodGet     = http.MethodGet
+	MethodHead    = http.MethodHead
+	MethodPost    = http.MethodPost
+	MethodPut     = http.MethodPut
+	MethodPatch   = http.MethodPatch
+	MethodDelete  = http.MethodDelete
+	MethodConnect = http.MethodConnect
+	MethodOptions = http.MethodOptions
+	MethodTrace   = http.MethodTrace
+)
+
+const (
+	StateNew      = http.StateNew
+	StateActive   = http.StateActive
+	StateIdle     = http.StateIdle
+	StateHijacked = http.StateHijacked
+	StateClosed   = http.StateClosed
+)
+
+const (
+	StatusContinue                      = http.StatusContinue
+	StatusSwitchingProtocols            = http.StatusSwitchingProtocols
+	StatusProcessing                    = http.StatusProcessing
+	StatusEarlyHints                    = http.StatusEarlyHints
+	StatusOK                            = http.StatusOK
+	StatusCreated                       = http.StatusCreated
+	StatusAccepted                      = http.StatusAccepted
+	StatusNonAuthoritativeInfo          = http.StatusNonAuthoritativeInfo
+	StatusNoContent                     = http.StatusNoContent
+	StatusResetContent                  = http.StatusResetContent
+	StatusPartialContent                = http.StatusPartialContent
+	StatusMultiStatus                   = http.StatusMultiStatus
+	StatusAlreadyReported               = http.StatusAlreadyReported
+	StatusIMUsed                        = http.StatusIMUsed
+	StatusMultipleChoices               = http.StatusMultipleChoices
+	StatusMovedPermanently              = http.StatusMovedPermanently
+	StatusFound                         = http.StatusFound
+	StatusSeeOther                      = http.StatusSeeOther
+	StatusNotModified                   = http.StatusNotModified
+	StatusUseProxy                      = http.StatusUseProxy
+	StatusTemporaryRedirect             = http.StatusTemporaryRedirect
+	StatusPermanentRedirect             = http.StatusPermanentRedirect
+	StatusBadRequest                    = http.StatusBadRequest
+	StatusUnauthorized                  = http.StatusUnauthorized
+	StatusPaymentRequired               = http.StatusPaymentRequired
+	StatusForbidden                     = http.StatusForbidden
+	StatusNotFound                      = http.StatusNotFound
+	StatusMethodNotAllowed              = http.StatusMethodNotAllowed
+	StatusNotAcceptable                 = http.StatusNotAcceptable
+	StatusProxyAuthRequired             = http.StatusProxyAuthRequired
+	StatusRequestTimeout                = http.StatusRequestTimeout
+	StatusConflict                      = http.StatusConflict
+	StatusGone                          = http.StatusGone
+	StatusLengthRequired                = http.StatusLengthRequired
+	StatusPreconditionFailed            = http.StatusPreconditionFailed
+	StatusRequestEntityTooLarge         = http.StatusRequestEntityTooLarge
+	StatusRequestURITooLong             = http.StatusRequestURITooLong
+	StatusUnsupportedMediaType          = http.StatusUnsupportedMediaType
+	StatusRequestedRangeNotSatisfiable  = http.StatusRequestedRangeNotSatisfiable
+	StatusExpectationFailed             = http.StatusExpectationFailed
+	StatusTeapot                        = http.StatusTeapot
+	StatusMisdirectedRequest            = http.StatusMisdirectedRequest
+	StatusUnprocessableEntity           = http.StatusUnprocessableEntity
+	StatusLocked                        = http.StatusLocked
+	StatusFailedDependency              = http.StatusFailedDependency
+	StatusTooEarly                      = http.StatusTooEarly
+	StatusUpgradeRequired               = http.StatusUpgradeRequired
+	StatusPreconditionRequired          = http.StatusPreconditionRequired
+	StatusTooManyRequests               = http.StatusTooManyRequests
+	StatusRequestHeaderFieldsTooLarge   = http.StatusRequestHeaderFieldsTooLarge
+	StatusUnavailableForLegalReasons    = http.StatusUnavailableForLegalReasons
+	StatusInternalServerError           = http.StatusInternalServerError
+	StatusNotImplemented                = http.StatusNotImplemented
+	StatusBadGateway                    = http.StatusBadGateway
+	StatusServiceUnavailable            = http.StatusServiceUnavailable
+	StatusGatewayTimeout                = http.StatusGatewayTimeout
+	StatusHTTPVersionNotSupported       = http.StatusHTTPVersionNotSupported
+	StatusVariantAlsoNegotiates         = http.StatusVariantAlsoNegotiates
+	StatusInsufficientStorage           = http.StatusInsufficientStorage
+	StatusLoopDetected                  = http.StatusLoopDetected
+	StatusNotExtended                   = http.StatusNotExtended
+	StatusNetworkAuthenticationRequired = http.StatusNetworkAuthenticationRequired
+)
+
+const (
+	DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+	TimeFormat            = http.TimeFormat
+)
+
+var (
+	ErrServerClosed    = http.ErrServerClosed
+	ErrUseLastResponse = http.ErrUseLastResponse
+	ErrNoCookie        = http.ErrNoCookie
+	ErrNoLocation      = http.ErrNoLocation
+	ErrHandlerTimeout  = http.ErrHandlerTimeout
+	ErrAbortHandler    = http.ErrAbortHandler
+	ErrBodyNotAllowed  = http.ErrBodyNotAllowed
+	ErrMissingFile     = http.ErrMissingFile
+
+	NoBody = http.NoBody
+)
+
+var (
+	CanonicalHeaderKey    = http.CanonicalHeaderKey
+	DetectContentType     = http.DetectContentType
+	Error                 = http.Error
+	FileServer            = http.FileServer
+	MaxBytesReader        = http.MaxBytesReader
+	NewRequest            = http.NewRequest
+	NewRequestWithContext = http.NewRequestWithContext
+	NotFound              = http.NotFound
+	NotFoundHandler       = http.NotFoundHandler
+	ParseHTTPVersion      = http.ParseHTTPVersion
+	ParseTime             = http.ParseTime
+	ProxyFromEnvironment  = http.ProxyFromEnvironment
+	ProxyURL              = http.ProxyURL
+	ReadRequest           = http.ReadRequest
+	ReadResponse          = http.ReadResponse
+	Redirect              = http.Redirect
+	RedirectHandler       = http.RedirectHandler
+	ServeContent          = http.ServeContent
+	ServeFile             = http.ServeFile
+	SetCookie             = http.SetCookie
+	StatusText            = http.StatusText
+	StripPrefix           = http.StripPrefix
+	TimeoutHandler        = http.TimeoutHandler
+)
@@ -2,7 +2,12 @@ package net
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/iahmedov/gomon"
 )
@@ -12,8 +17,35 @@ type wrappedListener struct {
 
 	et  gomon.EventTracker
 	ctx context.Context
+
+	accepts int64
+
+	mu sync.Mutex
+	// connections which are not closed yet
+	active map[*wrappedNetConn]struct{}
+	// since the last snapshot
+	windowStart   time.Time
+	windowAccepts int64
+	lifetime      *gomon.Histogram
+	acceptErrors  map[string]int64
+	protocols     map[string]int64
+	// bytes of closed connections
+	readBytes, writeBytes int64
+
+	stop chan struct{}
+	once sync.Once
 }
 
+var (
+	KeyActive       = "active"
+	KeyAccepts      = "accepts"
+	KeyAcceptRate   = "accept-rate"
+	KeyAcceptErrors = "accept-errors"
+	KeyLifetime     = "lifetime"
+	KeyClosed       = "closed"
+	KeyProtocols    = "protocols"
+)
+
 var _ net.Listener = (*wrappedListener)(nil)
 
 func MonitoredListener(l net.Listener) net.Listener {
@@ -22,40 +54,167 @@ func MonitoredListener(l net.Listener) net.Listener {
 	et.SetFingerprint("net-listener")
 	ctx := context.Background()
 	wl := &wrappedListener{
-		Listener: l,
-		et:       et,
-		ctx:      gomon.WithContext(ctx, et),
+		Listener:     l,
+		et:           et,
+		ctx:          gomon.WithContext(ctx, et),
+		active:       make(map[*wrappedNetConn]struct{}),
+		windowStart:  time.Now(),
+		lifetime:     gomon.NewHistogram(nil),
+		acceptErrors: make(map[string]int64),
+		protocols:    make(map[string]int64),
+		stop:         make(chan struct{}),
+	}
+	if interval := loadConfig().ListenerStatInterval; interval > 0 {
+		go wl.run(interval)
 	}
 	return wl
 }
 
-// not concurrency safe
-func (w *wrappedListener) incrementCounter(key string) {
-	v := w.et.Get(key)
-	var counter int = 0
-	if v != nil {
-		counter = v.(int)
+// ListenerSnapshot returns current stats of MonitoredListener,
+// it is nil if l is not monitored
+func ListenerSnapshot(l net.Listener) map[string]interface{} {
+	wl, ok := l.(*wrappedListener)
+	if !ok {
+		return nil
 	}
-	counter++
-	w.et.Set(key, counter)
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	return wl.snapshot(time.Now(), false)
 }
 
 func (w *wrappedListener) Accept() (conn net.Conn, err error) {
-	w.incrementCounter("conns")
 	conn, err = w.Listener.Accept()
 	if err != nil {
+		w.acceptError(err)
+		return
+	}
+
+	atomic.AddInt64(&w.accepts, 1)
+	conn = MonitoredConn(conn, w.ctx)
+	if wnc := unwrapNetConn(conn); wnc != nil {
+		wnc.listener = w
+		w.mu.Lock()
+		w.active[wnc] = struct{}{}
+		w.windowAccepts++
+		w.mu.Unlock()
+	}
+	return
+}
+
+func (w *wrappedListener) acceptError(err error) {
+	kind := "other"
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		kind = "timeout"
+	} else if errors.Is(err, net.ErrClosed) {
+		kind = "closed"
+	} else if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+		kind = "fd-limit"
+	} else if errors.Is(err, syscall.ECONNABORTED) {
+		kind = "aborted"
+	}
+
+	// et is shared by concurrent Accept calls, w.mu guards it
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.acceptErrors[kind]++
+	if kind != "closed" {
 		w.et.AddError(err)
 	}
+}
+
+func (w *wrappedListener) connClosed(c *wrappedNetConn) {
+	w.lifetime.Observe(time.Since(c.created))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.active, c)
+	w.readBytes += atomic.LoadInt64(&c.readSize)
+	w.writeBytes += atomic.LoadInt64(&c.writeSize)
+}
+
+// connections by protocol, see PluginConfig.SniffProtocol
+func (w *wrappedListener) protocolDetected(protocol string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.protocols[protocol]++
+}
+
+func (w *wrappedListener) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			w.report(now, false)
+		}
+	}
+}
 
-	if conn != nil {
-		conn = MonitoredConn(conn, w.ctx)
+func (w *wrappedListener) report(now time.Time, closed bool) {
+	et := w.et.NewChild(false)
+	et.SetFingerprint("net-listener-snapshot")
+	w.mu.Lock()
+	for k, v := range w.snapshot(now, true) {
+		et.Set(k, v)
 	}
+	w.mu.Unlock()
+	if closed {
+		et.Set(KeyClosed, true)
+	}
+	et.Finish()
+}
 
-	return
+// snapshot is called with w.mu held, reset starts new window
+func (w *wrappedListener) snapshot(now time.Time, reset bool) map[string]interface{} {
+	// bytes of active connections are included as well
+	readBytes, writeBytes := w.readBytes, w.writeBytes
+	for c := range w.active {
+		readBytes += atomic.LoadInt64(&c.readSize)
+		writeBytes += atomic.LoadInt64(&c.writeSize)
+	}
+
+	acceptErrors := make(map[string]int64, len(w.acceptErrors))
+	for k, v := range w.acceptErrors {
+		acceptErrors[k] = v
+	}
+
+	protocols := make(map[string]int64, len(w.protocols))
+	for k, v := range w.protocols {
+		protocols[k] = v
+	}
+
+	var rate float64
+	if elapsed := now.Sub(w.windowStart); elapsed > 0 {
+		rate = float64(w.windowAccepts) / elapsed.Seconds()
+	}
+
+	kv := map[string]interface{}{
+		KeyActive:       len(w.active),
+		KeyAccepts:      atomic.LoadInt64(&w.accepts),
+		KeyAcceptRate:   rate,
+		KeyAcceptErrors: acceptErrors,
+		KeyProtocols:    protocols,
+		KeyLifetime:     w.lifetime.KVData(),
+		KeyReadBytes:    readBytes,
+		KeyWriteBytes:   writeBytes,
+	}
+	if reset {
+		w.windowStart = now
+		w.windowAccepts = 0
+		w.lifetime.Reset()
+	}
+	return kv
 }
 
 func (w *wrappedListener) Close() (err error) {
 	defer func() {
+		w.once.Do(func() {
+			close(w.stop)
+			w.report(time.Now(), true)
+		})
+		w.mu.Lock()
+		defer w.mu.Unlock()
 		if err != nil {
 			w.et.AddError(err)
 		}
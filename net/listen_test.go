@@ -0,0 +1,93 @@
+package net
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// failingListener returns err from every Accept
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+func (l *failingListener) Close() error {
+	return nil
+}
+
+func TestListenerAcceptErrors(t *testing.T) {
+	l := MonitoredListener(&failingListener{err: syscall.EMFILE})
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := l.Accept(); err == nil {
+					t.Error("accept succeeded")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := ListenerSnapshot(l)
+	if errs := snapshot[KeyAcceptErrors].(map[string]int64); errs["fd-limit"] != 800 {
+		t.Errorf("accept errors = %v, want 800 fd-limit", errs)
+	}
+	if errs, _ := l.(*wrappedListener).et.Get("error").([]error); len(errs) != 800 {
+		t.Errorf("listener has %d errors, want 800", len(errs))
+	}
+}
+
+func TestListenerConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := MonitoredListener(inner)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- c
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+
+	client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := server.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := ListenerSnapshot(l)
+	if snapshot[KeyActive] != 1 || snapshot[KeyAccepts] != int64(1) || snapshot[KeyReadBytes] != int64(5) {
+		t.Errorf("snapshot with open conn = %v", snapshot)
+	}
+
+	server.Close()
+	snapshot = ListenerSnapshot(l)
+	if snapshot[KeyActive] != 0 || snapshot[KeyReadBytes] != int64(5) {
+		t.Errorf("snapshot after close = %v", snapshot)
+	}
+
+	if ListenerSnapshot(inner) != nil {
+		t.Error("snapshot of not monitored listener")
+	}
+}
@@ -0,0 +1,140 @@
+package net
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// connOps aggregates reads or writes of a connection,
+// counters are updated atomically since Close may be
+// called while other goroutine is reading
+type connOps struct {
+	direction      string
+	stallThreshold time.Duration
+	latency        *gomon.Histogram
+
+	ops, zero, partial int64
+	timeouts, stalls   int64
+}
+
+// connOp is a single read/write in progress, it is kept
+// on the stack of Read/Write unless stall detection is on
+type connOp struct {
+	conn  *wrappedNetConn
+	ops   *connOps
+	start time.Time
+	size  int
+	timer *time.Timer
+}
+
+var (
+	KeyReads     = "reads"
+	KeyWrites    = "writes"
+	KeyIdleTime  = "idle-time"
+	KeyMaxIdle   = "max-idle"
+	KeyOperation = "operation"
+	KeyBlocked   = "blocked"
+	KeySize      = "size"
+)
+
+func newConnOps(direction string, stallThreshold time.Duration) *connOps {
+	return &connOps{
+		direction:      direction,
+		stallThreshold: stallThreshold,
+		latency:        gomon.NewHistogram(nil),
+	}
+}
+
+func (w *wrappedNetConn) startOp(ops *connOps, size int) (op connOp) {
+	op = connOp{
+		conn:  w,
+		ops:   ops,
+		start: time.Now(),
+		size:  size,
+	}
+	if ops.stallThreshold > 0 {
+		stalled := op
+		op.timer = time.AfterFunc(ops.stallThreshold, stalled.stalled)
+	}
+	return
+}
+
+// done returns true if err is a timeout,
+// timeouts are counted instead of being added as errors
+func (op *connOp) done(n int, err error) (timeout bool) {
+	if op.timer != nil {
+		op.timer.Stop()
+	}
+	now := time.Now()
+	ops := op.ops
+	ops.latency.Observe(now.Sub(op.start))
+	atomic.AddInt64(&ops.ops, 1)
+
+	if n == 0 && op.size > 0 {
+		atomic.AddInt64(&ops.zero, 1)
+	} else if n < op.size && ops.direction == "write" {
+		// partial reads are normal
+		atomic.AddInt64(&ops.partial, 1)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		atomic.AddInt64(&ops.timeouts, 1)
+		timeout = true
+	}
+	if n > 0 {
+		op.conn.transferred(now)
+	}
+	return
+}
+
+// stalled is called by timer while operation is still blocked
+func (op *connOp) stalled() {
+	if atomic.LoadInt32(&op.conn.closed) != 0 {
+		return
+	}
+	atomic.AddInt64(&op.ops.stalls, 1)
+
+	et := op.conn.et.NewChild(false)
+	et.SetFingerprint("net-conn-stall")
+	et.Set(KeyOperation, op.ops.direction)
+	et.Set(KeyBlocked, time.Since(op.start))
+	et.Set(KeySize, op.size)
+	et.Finish()
+}
+
+// transferred tracks the longest period without any data
+func (w *wrappedNetConn) transferred(now time.Time) {
+	last := atomic.SwapInt64(&w.lastTransfer, now.UnixNano())
+	idle := now.UnixNano() - last
+	for {
+		max := atomic.LoadInt64(&w.maxIdle)
+		if idle <= max || atomic.CompareAndSwapInt64(&w.maxIdle, max, idle) {
+			return
+		}
+	}
+}
+
+func (w *wrappedNetConn) fillOps(now time.Time) {
+	w.et.Set(KeyReadBytes, atomic.LoadInt64(&w.readSize))
+	w.et.Set(KeyWriteBytes, atomic.LoadInt64(&w.writeSize))
+	w.et.Set(KeyReads, w.reads.KVData())
+	w.et.Set(KeyWrites, w.writes.KVData())
+	w.et.Set(KeyIdleTime, time.Duration(now.UnixNano()-atomic.LoadInt64(&w.lastTransfer)))
+	w.et.Set(KeyMaxIdle, time.Duration(atomic.LoadInt64(&w.maxIdle)))
+}
+
+func (o *connOps) KVData() map[string]interface{} {
+	kv := map[string]interface{}{
+		"ops":      atomic.LoadInt64(&o.ops),
+		"zero":     atomic.LoadInt64(&o.zero),
+		"timeouts": atomic.LoadInt64(&o.timeouts),
+		"stalls":   atomic.LoadInt64(&o.stalls),
+		"latency":  o.latency.KVData(),
+	}
+	if o.direction == "write" {
+		kv["partial"] = atomic.LoadInt64(&o.partial)
+	}
+	return kv
+}
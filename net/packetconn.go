@@ -0,0 +1,270 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+type wrappedPacketConn struct {
+	parent net.PacketConn
+	et     gomon.EventTracker
+
+	mu sync.Mutex
+	// since the last summary and since creation
+	window, total *packetStat
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// packetStat aggregates datagrams per peer, number
+// of peers is limited by PacketMaxPeers
+type packetStat struct {
+	maxPeers int
+	peers    map[string]*peerStat
+	other    peerStat
+
+	fullBuffer int64
+	oversize   int64
+	timeouts   int64
+}
+
+type peerStat struct {
+	readPackets, readBytes   int64
+	writePackets, writeBytes int64
+}
+
+var (
+	KeyPeers           = "peers"
+	KeyOtherPeers      = "other-peers"
+	KeyReadPackets     = "read-packets"
+	KeyReadBytes       = "read-bytes"
+	KeyWritePackets    = "write-packets"
+	KeyWriteBytes      = "write-bytes"
+	KeyFullBufferReads = "full-buffer-reads"
+	KeyOversizeWrites  = "oversize-writes"
+	KeyTimeouts        = "timeouts"
+)
+
+var _ net.PacketConn = (*wrappedPacketConn)(nil)
+
+// MonitoredPacketConn is for unconnected sockets (e.g. net.ListenPacket),
+// traffic is aggregated per peer and reported periodically
+func MonitoredPacketConn(pc net.PacketConn, ctx context.Context) net.PacketConn {
+	et := gomon.FromContext(ctx).NewChild(false)
+	et.SetFingerprint("net-packetconn")
+	config := loadConfig()
+
+	w := &wrappedPacketConn{
+		parent: pc,
+		et:     et,
+		window: newPacketStat(config.PacketMaxPeers),
+		total:  newPacketStat(config.PacketMaxPeers),
+		stop:   make(chan struct{}),
+	}
+	if laddr := pc.LocalAddr(); laddr != nil {
+		et.Set("laddr", laddr.String())
+		et.Set("laddr-net", laddr.Network())
+	}
+
+	if config.PacketSummaryInterval > 0 {
+		go w.run(config.PacketSummaryInterval)
+	}
+	return w
+}
+
+func (w *wrappedPacketConn) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.summary()
+		}
+	}
+}
+
+func (w *wrappedPacketConn) summary() {
+	et := w.et.NewChild(false)
+	et.SetFingerprint("net-packetconn-summary")
+	w.mu.Lock()
+	w.window.fill(et)
+	w.window = newPacketStat(w.window.maxPeers)
+	w.mu.Unlock()
+	et.Finish()
+}
+
+func (w *wrappedPacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	n, addr, err = w.parent.ReadFrom(b)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		w.error(err)
+		return
+	}
+	// datagrams which do not fit are silently truncated, read which
+	// filled the whole buffer is likely (but not surely) truncated
+	fullBuffer := len(b) > 0 && n == len(b)
+	w.window.read(addr, n, fullBuffer)
+	w.total.read(addr, n, fullBuffer)
+	return
+}
+
+func (w *wrappedPacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	n, err = w.parent.WriteTo(b, addr)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			w.window.oversize++
+			w.total.oversize++
+			return
+		}
+		w.error(err)
+		return
+	}
+	w.window.write(addr, n)
+	w.total.write(addr, n)
+	return
+}
+
+// error is called with w.mu held, deadline errors are expected on
+// long-lived sockets, they are counted instead of being added one by one
+func (w *wrappedPacketConn) error(err error) {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		w.window.timeouts++
+		w.total.timeouts++
+		return
+	}
+	w.et.AddError(err)
+}
+
+func (w *wrappedPacketConn) Close() (err error) {
+	err = w.parent.Close()
+	w.once.Do(func() {
+		close(w.stop)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			w.et.AddError(err)
+		}
+		w.total.fill(w.et)
+		w.et.Finish()
+	})
+	return
+}
+
+func (w *wrappedPacketConn) LocalAddr() net.Addr {
+	return w.parent.LocalAddr()
+}
+
+func (w *wrappedPacketConn) SetDeadline(t time.Time) (err error) {
+	if err = w.parent.SetDeadline(t); err != nil {
+		w.et.AddError(err)
+	}
+	return
+}
+
+func (w *wrappedPacketConn) SetReadDeadline(t time.Time) (err error) {
+	if err = w.parent.SetReadDeadline(t); err != nil {
+		w.et.AddError(err)
+	}
+	return
+}
+
+func (w *wrappedPacketConn) SetWriteDeadline(t time.Time) (err error) {
+	if err = w.parent.SetWriteDeadline(t); err != nil {
+		w.et.AddError(err)
+	}
+	return
+}
+
+func newPacketStat(maxPeers int) *packetStat {
+	return &packetStat{
+		maxPeers: maxPeers,
+		peers:    make(map[string]*peerStat),
+	}
+}
+
+func (s *packetStat) peer(addr net.Addr) *peerStat {
+	if addr == nil {
+		return &s.other
+	}
+	key := addr.String()
+	p, ok := s.peers[key]
+	if !ok {
+		if len(s.peers) >= s.maxPeers {
+			return &s.other
+		}
+		p = &peerStat{}
+		s.peers[key] = p
+	}
+	return p
+}
+
+func (s *packetStat) read(addr net.Addr, n int, fullBuffer bool) {
+	p := s.peer(addr)
+	p.readPackets++
+	p.readBytes += int64(n)
+	if fullBuffer {
+		s.fullBuffer++
+	}
+}
+
+func (s *packetStat) write(addr net.Addr, n int) {
+	p := s.peer(addr)
+	p.writePackets++
+	p.writeBytes += int64(n)
+}
+
+func (s *packetStat) fill(et gomon.EventTracker) {
+	sum := s.fillPeers(et)
+	et.Set(KeyReadPackets, sum.readPackets)
+	et.Set(KeyReadBytes, sum.readBytes)
+	et.Set(KeyWritePackets, sum.writePackets)
+	et.Set(KeyWriteBytes, sum.writeBytes)
+	et.Set(KeyFullBufferReads, s.fullBuffer)
+	et.Set(KeyOversizeWrites, s.oversize)
+	et.Set(KeyTimeouts, s.timeouts)
+}
+
+func (s *packetStat) fillPeers(et gomon.EventTracker) (sum peerStat) {
+	peers := make(map[string]interface{}, len(s.peers))
+	for addr, p := range s.peers {
+		peers[addr] = p.KVData()
+		sum.add(p)
+	}
+	sum.add(&s.other)
+
+	et.Set(KeyPeers, peers)
+	if s.other != (peerStat{}) {
+		et.Set(KeyOtherPeers, s.other.KVData())
+	}
+	return
+}
+
+func (p *peerStat) add(o *peerStat) {
+	p.readPackets += o.readPackets
+	p.readBytes += o.readBytes
+	p.writePackets += o.writePackets
+	p.writeBytes += o.writeBytes
+}
+
+func (p *peerStat) KVData() map[string]interface{} {
+	return map[string]interface{}{
+		KeyReadPackets:  p.readPackets,
+		KeyReadBytes:    p.readBytes,
+		KeyWritePackets: p.writePackets,
+		KeyWriteBytes:   p.writeBytes,
+	}
+}
@@ -0,0 +1,175 @@
+package net
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// Resolver is net.Resolver which creates tracker for every lookup,
+// lookups of hosts can be cached for CacheTTL
+type Resolver struct {
+	// nil means net.DefaultResolver
+	Resolver *net.Resolver
+	// zero disables cache
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]resolverCacheItem
+}
+
+type resolverCacheItem struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+var (
+	KeyLookup   = "lookup"
+	KeyHost     = "host"
+	KeyAnswers  = "answers"
+	KeyCacheHit = "cache-hit"
+	KeyDNSError = "dns-error"
+)
+
+func (r *Resolver) resolver() *net.Resolver {
+	if r == nil || r.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return r.Resolver
+}
+
+func (r *Resolver) startLookup(ctx context.Context, lookup, host string) gomon.EventTracker {
+	et := gomon.FromContext(ctx).NewChild(false)
+	et.SetFingerprint("net-dns")
+	et.Set(KeyLookup, lookup)
+	et.Set(KeyHost, host)
+	return et
+}
+
+func (r *Resolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return
+}
+
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) (addrs []net.IPAddr, err error) {
+	et := r.startLookup(ctx, "ip", host)
+	defer et.Finish()
+
+	if addrs, ok := r.cached(host); ok {
+		et.Set(KeyCacheHit, true)
+		et.Set(KeyAnswers, ipAddrStrings(addrs))
+		return addrs, nil
+	}
+
+	start := time.Now()
+	addrs, err = r.resolver().LookupIPAddr(ctx, host)
+	et.Set(KeyLatency, time.Since(start))
+	if err != nil {
+		lookupError(et, err)
+		return
+	}
+	if r != nil && r.CacheTTL > 0 {
+		et.Set(KeyCacheHit, false)
+		r.store(host, addrs)
+	}
+	et.Set(KeyAnswers, ipAddrStrings(addrs))
+	return
+}
+
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error) {
+	et := r.startLookup(ctx, "srv", name)
+	defer et.Finish()
+
+	start := time.Now()
+	cname, addrs, err = r.resolver().LookupSRV(ctx, service, proto, name)
+	et.Set(KeyLatency, time.Since(start))
+	if err != nil {
+		lookupError(et, err)
+		return
+	}
+	answers := make([]string, 0, len(addrs))
+	for _, srv := range addrs {
+		answers = append(answers, net.JoinHostPort(srv.Target, strconv.Itoa(int(srv.Port))))
+	}
+	et.Set(KeyAnswers, answers)
+	return
+}
+
+func (r *Resolver) LookupTXT(ctx context.Context, name string) (txts []string, err error) {
+	et := r.startLookup(ctx, "txt", name)
+	defer et.Finish()
+
+	start := time.Now()
+	txts, err = r.resolver().LookupTXT(ctx, name)
+	et.Set(KeyLatency, time.Since(start))
+	if err != nil {
+		lookupError(et, err)
+		return
+	}
+	et.Set(KeyAnswers, txts)
+	return
+}
+
+func (r *Resolver) cached(host string) ([]net.IPAddr, bool) {
+	if r == nil || r.CacheTTL <= 0 {
+		return nil, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.cache[host]
+	if !ok || time.Now().After(item.expires) {
+		return nil, false
+	}
+	return item.addrs, true
+}
+
+func (r *Resolver) store(host string, addrs []net.IPAddr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache == nil {
+		r.cache = make(map[string]resolverCacheItem)
+	}
+	now := time.Now()
+	for k, item := range r.cache {
+		if now.After(item.expires) {
+			delete(r.cache, k)
+		}
+	}
+	r.cache[host] = resolverCacheItem{addrs, now.Add(r.CacheTTL)}
+}
+
+func lookupError(et gomon.EventTracker, err error) {
+	et.AddError(err)
+	dnsErr, ok := err.(*net.DNSError)
+	if !ok {
+		return
+	}
+	switch {
+	case dnsErr.IsNotFound:
+		et.Set(KeyDNSError, "not-found")
+	case dnsErr.IsTimeout:
+		et.Set(KeyDNSError, "timeout")
+	case dnsErr.IsTemporary:
+		et.Set(KeyDNSError, "temporary")
+	default:
+		et.Set(KeyDNSError, "other")
+	}
+}
+
+func ipAddrStrings(addrs []net.IPAddr) []string {
+	s := make([]string, 0, len(addrs))
+	for _, ip := range addrs {
+		s = append(s, ip.String())
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package net
+
+import (
+	"encoding/hex"
+	"regexp"
+	"sync"
+
+	"github.com/iahmedov/gomon"
+)
+
+// Redactor is applied to captured payload before it is rendered,
+// direction is "read" or "write". Returned slice may be the given one
+type Redactor func(direction string, b []byte) []byte
+
+// payloadSampler keeps first bytes of one direction of a connection
+// and optionally a part of every operation until budget is used
+type payloadSampler struct {
+	// Close may be called while other goroutine reads
+	mu sync.Mutex
+
+	size  int
+	first []byte
+
+	opSize, budget int
+	offset         int64
+	ops            []opSample
+}
+
+type opSample struct {
+	offset int64
+	size   int
+	data   []byte
+}
+
+var (
+	KeyReadSample     = "read-sample"
+	KeyWriteSample    = "write-sample"
+	KeyReadOpSamples  = "read-op-samples"
+	KeyWriteOpSamples = "write-op-samples"
+)
+
+// RedactRegexp replaces every match of re with '*'
+func RedactRegexp(re *regexp.Regexp) Redactor {
+	return func(direction string, b []byte) []byte {
+		return re.ReplaceAllFunc(b, func(m []byte) []byte {
+			for i := range m {
+				m[i] = '*'
+			}
+			return m
+		})
+	}
+}
+
+// nil if sampling is disabled
+func newPayloadSampler(config *PluginConfig) *payloadSampler {
+	if config.SampleSize <= 0 && (config.SampleOpSize <= 0 || config.SampleOpBudget <= 0) {
+		return nil
+	}
+	return &payloadSampler{
+		size:   config.SampleSize,
+		opSize: config.SampleOpSize,
+		budget: config.SampleOpBudget,
+	}
+}
+
+func (s *payloadSampler) observe(b []byte) {
+	if s == nil || len(b) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if left := s.size - len(s.first); left > 0 {
+		s.first = append(s.first, b[:min(left, len(b))]...)
+	}
+	if n := min(s.opSize, min(s.budget, len(b))); n > 0 {
+		s.ops = append(s.ops, opSample{
+			offset: s.offset,
+			size:   len(b),
+			data:   append([]byte(nil), b[:n]...),
+		})
+		s.budget -= n
+	}
+	s.offset += int64(len(b))
+}
+
+func (s *payloadSampler) fill(et gomon.EventTracker, direction, key, opsKey string, redactors []Redactor) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.first) > 0 {
+		et.Set(key, renderSample(direction, s.first, redactors))
+	}
+	if len(s.ops) > 0 {
+		ops := make([]map[string]interface{}, 0, len(s.ops))
+		for _, op := range s.ops {
+			ops = append(ops, map[string]interface{}{
+				"offset": op.offset,
+				"size":   op.size,
+				"sample": renderSample(direction, op.data, redactors),
+			})
+		}
+		et.Set(opsKey, ops)
+	}
+}
+
+// renderSample returns hex+ASCII dump of redacted payload
+func renderSample(direction string, b []byte, redactors []Redactor) string {
+	b = append([]byte(nil), b...)
+	for _, r := range redactors {
+		b = r(direction, b)
+	}
+	return hex.Dump(b)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,166 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+)
+
+// protocolSniffer detects protocol from the first bytes sent in
+// either direction, client speaks first in most protocols, server
+// in some (e.g. MySQL greeting)
+type protocolSniffer struct {
+	mu       sync.Mutex
+	in, out  []byte
+	done     bool
+	protocol string
+}
+
+var KeyProtocol = "protocol"
+
+// bytes kept per direction before protocol is considered unknown
+const kSniffBytes = 16
+
+var protocolPrefixes = []struct {
+	prefix   []byte
+	protocol string
+}{
+	{[]byte("PRI * HTTP/2.0\r\n"), "http2"},
+	{[]byte("GET "), "http1"},
+	{[]byte("HEAD "), "http1"},
+	{[]byte("POST "), "http1"},
+	{[]byte("PUT "), "http1"},
+	{[]byte("DELETE "), "http1"},
+	{[]byte("OPTIONS "), "http1"},
+	{[]byte("PATCH "), "http1"},
+	{[]byte("CONNECT "), "http1"},
+	{[]byte("TRACE "), "http1"},
+	{[]byte("HTTP/1."), "http1"},
+}
+
+func newProtocolSniffer(config *PluginConfig) *protocolSniffer {
+	if !config.SniffProtocol {
+		return nil
+	}
+	return &protocolSniffer{}
+}
+
+// observe returns protocol once it is detected, "unknown"
+// if it could not be detected from the first bytes
+func (s *protocolSniffer) observe(remote bool, b []byte) (protocol string, detected bool) {
+	if len(b) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+
+	buf := &s.out
+	if remote {
+		buf = &s.in
+	}
+	if len(*buf) < kSniffBytes {
+		*buf = append(*buf, b[:min(kSniffBytes-len(*buf), len(b))]...)
+	}
+
+	inProto, inMore := detectProtocol(s.in)
+	outProto, outMore := detectProtocol(s.out)
+	switch {
+	case len(inProto) > 0:
+		s.protocol = inProto
+	case len(outProto) > 0:
+		s.protocol = outProto
+	case !inMore && !outMore:
+		s.protocol = "unknown"
+	default:
+		return
+	}
+	s.done = true
+	return s.protocol, true
+}
+
+// closed returns "unknown" if something was sent
+// but protocol was not detected yet
+func (s *protocolSniffer) closed() (protocol string, detected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || len(s.in)+len(s.out) == 0 {
+		return
+	}
+	s.done = true
+	s.protocol = "unknown"
+	return s.protocol, true
+}
+
+// detectProtocol returns more=true if b may become
+// a known protocol with more bytes
+func detectProtocol(b []byte) (protocol string, more bool) {
+	if len(b) == 0 {
+		return "", true
+	}
+
+	for _, p := range protocolPrefixes {
+		n := min(len(b), len(p.prefix))
+		if !bytes.Equal(b[:n], p.prefix[:n]) {
+			continue
+		}
+		if n == len(p.prefix) {
+			return p.protocol, false
+		}
+		more = true
+	}
+
+	// TLS handshake record, ClientHello or ServerHello
+	if b[0] == 0x16 {
+		if len(b) < 6 {
+			return "", more || len(b) < 2 || b[1] == 0x03
+		}
+		if b[1] == 0x03 && (b[5] == 0x01 || b[5] == 0x02) {
+			return "tls", false
+		}
+	}
+
+	// RESP array of bulk strings, e.g. "*1\r\n$4\r\nPING\r\n"
+	if b[0] == '*' {
+		if len(b) < 4 {
+			return "", true
+		}
+		i := 1
+		for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+			i++
+		}
+		if i > 1 && i+1 < len(b) && b[i] == '\r' && b[i+1] == '\n' {
+			return "redis", false
+		}
+	}
+
+	// PostgreSQL startup message or SSL/GSS encryption request
+	if len(b) < 8 {
+		if b[0] == 0 {
+			more = true
+		}
+	} else {
+		length := binary.BigEndian.Uint32(b[:4])
+		code := binary.BigEndian.Uint32(b[4:8])
+		switch {
+		case length == 8 && (code == 80877103 || code == 80877104):
+			return "postgres", false
+		case length >= 8 && length < 10000 && code == 196608:
+			return "postgres", false
+		}
+	}
+
+	// MySQL server greeting: 3 byte length, sequence 0, protocol 10
+	if len(b) < 5 {
+		more = true
+	} else if b[3] == 0 && b[4] == 0x0a {
+		length := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		if length > 0 && length < 1024 {
+			return "mysql", false
+		}
+	}
+
+	return "", more && len(b) < kSniffBytes
+}
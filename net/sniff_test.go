@@ -0,0 +1,59 @@
+package net
+
+import "testing"
+
+func TestDetectProtocol(t *testing.T) {
+	cases := []struct {
+		name     string
+		b        []byte
+		protocol string
+		more     bool
+	}{
+		{"empty", nil, "", true},
+		{"http1 request", []byte("GET / HTTP/1.1\r\n"), "http1", false},
+		{"http1 partial", []byte("PO"), "", true},
+		{"http1 response", []byte("HTTP/1.1 200 OK"), "http1", false},
+		{"http2 preface", []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"), "http2", false},
+		{"tls client hello", []byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01, 0x00}, "tls", false},
+		{"tls partial", []byte{0x16, 0x03}, "", true},
+		{"redis", []byte("*1\r\n$4\r\nPING\r\n"), "redis", false},
+		{"postgres ssl request", []byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}, "postgres", false},
+		{"postgres startup", []byte{0, 0, 0, 0x54, 0, 3, 0, 0}, "postgres", false},
+		{"mysql greeting", []byte{0x4a, 0, 0, 0, 0x0a, '8', '.', '0'}, "mysql", false},
+		{"unknown", []byte("hello there, friend"), "", false},
+	}
+	for _, c := range cases {
+		protocol, more := detectProtocol(c.b)
+		if protocol != c.protocol || more != c.more {
+			t.Errorf("%s: detectProtocol = %q, %v, want %q, %v", c.name, protocol, more, c.protocol, c.more)
+		}
+	}
+}
+
+func TestProtocolSniffer(t *testing.T) {
+	s := newProtocolSniffer(&PluginConfig{SniffProtocol: true})
+	// server greeting comes first
+	if _, detected := s.observe(true, []byte{0x4a, 0}); detected {
+		t.Fatal("detected from 2 bytes")
+	}
+	protocol, detected := s.observe(true, []byte{0, 0, 0x0a, '5'})
+	if !detected || protocol != "mysql" {
+		t.Errorf("observe = %q, %v, want mysql", protocol, detected)
+	}
+	if _, detected := s.observe(false, []byte("GET / HTTP/1.1")); detected {
+		t.Error("protocol detected twice")
+	}
+	if _, detected := s.closed(); detected {
+		t.Error("closed reported protocol after detection")
+	}
+
+	s = newProtocolSniffer(&PluginConfig{SniffProtocol: true})
+	s.observe(false, []byte("GE"))
+	if protocol, detected := s.closed(); !detected || protocol != "unknown" {
+		t.Errorf("closed = %q, %v, want unknown", protocol, detected)
+	}
+
+	if newProtocolSniffer(&PluginConfig{}) != nil {
+		t.Error("sniffer is created with SniffProtocol disabled")
+	}
+}
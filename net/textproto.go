@@ -0,0 +1,246 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// TextprotoConn is textproto.Conn which creates child tracker for
+// every command and its response. Commands are expected to be answered
+// in order, so pipelining works; multi-line payload read after response
+// (e.g. ReadDotBytes) is counted to the last answered command
+type TextprotoConn struct {
+	*textproto.Conn
+	et gomon.EventTracker
+
+	// serializes Cmd, so commands are queued in the order they are sent
+	cmdMu sync.Mutex
+
+	mu sync.Mutex
+	// commands waiting for response
+	pending []*textprotoExchange
+	// answered command, finished when next command or response comes
+	last     *textprotoExchange
+	commands int64
+	once     sync.Once
+}
+
+type textprotoExchange struct {
+	et      gomon.EventTracker
+	sent    time.Time
+	payload int64
+}
+
+// counts bytes read by DotReader
+type dotReader struct {
+	io.Reader
+	c *TextprotoConn
+}
+
+var (
+	KeyCommand       = "command"
+	KeyCode          = "code"
+	KeyLatency       = "latency"
+	KeyResponseLines = "response-lines"
+	KeyPayloadSize   = "payload-size"
+	KeyCommands      = "commands"
+)
+
+// MonitoredTextprotoConn is textproto.NewConn with monitoring,
+// net.Conn is monitored as well
+func MonitoredTextprotoConn(conn io.ReadWriteCloser, ctx context.Context) *TextprotoConn {
+	et := gomon.FromContext(ctx).NewChild(false)
+	et.SetFingerprint("net-textproto")
+	if c, ok := conn.(net.Conn); ok {
+		conn = MonitoredConn(c, gomon.WithContext(context.Background(), et))
+	}
+
+	return &TextprotoConn{
+		Conn: textproto.NewConn(conn),
+		et:   et,
+	}
+}
+
+// DialTextproto is textproto.Dial with monitoring
+func DialTextproto(network, addr string) (*TextprotoConn, error) {
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return MonitoredTextprotoConn(c, nil), nil
+}
+
+func (c *TextprotoConn) Cmd(format string, args ...interface{}) (id uint, err error) {
+	line := fmt.Sprintf(format, args...)
+	ex := &textprotoExchange{
+		et: c.et.NewChild(false),
+	}
+	ex.et.SetFingerprint("net-textproto-cmd")
+	// only the verb, arguments may contain credentials
+	if fields := strings.Fields(line); len(fields) > 0 {
+		ex.et.Set(KeyCommand, strings.ToUpper(fields[0]))
+	}
+
+	// c.mu is not held while writing, reading responses must not wait for it
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+
+	c.mu.Lock()
+	c.finishLast()
+	ex.sent = time.Now()
+	c.pending = append(c.pending, ex)
+	c.commands++
+	c.mu.Unlock()
+
+	id, err = c.Conn.Cmd("%s", line)
+	if err != nil {
+		c.mu.Lock()
+		c.unsent(ex, err)
+		c.mu.Unlock()
+	}
+	return
+}
+
+// unsent removes ex which was not sent from pending
+// commands, it will not be answered; c.mu is held
+func (c *TextprotoConn) unsent(ex *textprotoExchange, err error) {
+	for i, p := range c.pending {
+		if p == ex {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	ex.et.AddError(err)
+	ex.et.Finish()
+}
+
+func (c *TextprotoConn) ReadResponse(expectCode int) (code int, message string, err error) {
+	code, message, err = c.Conn.ReadResponse(expectCode)
+	c.responded(code, message, err)
+	return
+}
+
+func (c *TextprotoConn) ReadCodeLine(expectCode int) (code int, message string, err error) {
+	code, message, err = c.Conn.ReadCodeLine(expectCode)
+	c.responded(code, message, err)
+	return
+}
+
+func (c *TextprotoConn) ReadDotBytes() (b []byte, err error) {
+	b, err = c.Conn.ReadDotBytes()
+	c.addPayload(int64(len(b)), err)
+	return
+}
+
+func (c *TextprotoConn) ReadDotLines() (lines []string, err error) {
+	lines, err = c.Conn.ReadDotLines()
+	var n int64
+	for _, l := range lines {
+		n += int64(len(l)) + 1
+	}
+	c.addPayload(n, err)
+	return
+}
+
+func (c *TextprotoConn) DotReader() io.Reader {
+	return &dotReader{c.Conn.DotReader(), c}
+}
+
+func (c *TextprotoConn) Close() (err error) {
+	err = c.Conn.Close()
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.finishLast()
+		for _, ex := range c.pending {
+			// never answered
+			ex.et.Finish()
+		}
+		c.pending = nil
+		c.et.Set(KeyCommands, c.commands)
+		if err != nil {
+			c.et.AddError(err)
+		}
+		c.et.Finish()
+	})
+	return
+}
+
+func (c *TextprotoConn) responded(code int, message string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ex := c.respond(err)
+	if code == 0 {
+		if terr, ok := err.(*textproto.Error); ok {
+			code = terr.Code
+		}
+	}
+	if code > 0 {
+		ex.et.Set(KeyCode, code)
+	}
+	if len(message) > 0 {
+		ex.et.Set(KeyResponseLines, strings.Count(message, "\n")+1)
+		ex.payload += int64(len(message))
+	}
+}
+
+// respond takes the oldest pending command, response without
+// command (e.g. greeting) gets its own exchange; c.mu is held
+func (c *TextprotoConn) respond(err error) *textprotoExchange {
+	c.finishLast()
+
+	var ex *textprotoExchange
+	if len(c.pending) > 0 {
+		ex = c.pending[0]
+		c.pending = c.pending[1:]
+		ex.et.Set(KeyLatency, time.Since(ex.sent))
+	} else {
+		ex = &textprotoExchange{et: c.et.NewChild(false)}
+		ex.et.SetFingerprint("net-textproto-cmd")
+	}
+	if err != nil {
+		ex.et.AddError(err)
+	}
+	c.last = ex
+	return ex
+}
+
+func (c *TextprotoConn) addPayload(n int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return
+	}
+	c.last.payload += n
+	if err != nil {
+		c.last.et.AddError(err)
+	}
+}
+
+// c.mu is held
+func (c *TextprotoConn) finishLast() {
+	if c.last == nil {
+		return
+	}
+	c.last.et.Set(KeyPayloadSize, c.last.payload)
+	c.last.et.Finish()
+	c.last = nil
+}
+
+func (r *dotReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if err == io.EOF {
+		r.c.addPayload(int64(n), nil)
+	} else {
+		r.c.addPayload(int64(n), err)
+	}
+	return
+}
@@ -0,0 +1,446 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// TLSConn is tls.Conn which records handshake, for connections
+// owned by the caller (clients, custom servers)
+type TLSConn struct {
+	*tls.Conn
+	et   gomon.EventTracker
+	once sync.Once
+}
+
+// tlsListener returns *tls.Conn so that http.Server still sees
+// TLS connections, handshake is observed through tls.Config callbacks
+type tlsListener struct {
+	net.Listener
+	et     gomon.EventTracker
+	config *tls.Config
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// tlsHandshake of a connection accepted by tlsListener,
+// raw records are parsed until handshake is complete
+// to find plaintext alerts
+type tlsHandshake struct {
+	mu       sync.Mutex
+	start    time.Time
+	done     bool
+	in, out  tlsRecordParser
+	alert    string
+	alertBy  string
+	reported bool
+}
+
+type tlsRecordParser struct {
+	hdr     [5]byte
+	hdrLen  int
+	left    int
+	payload []byte
+}
+
+var (
+	KeyTLSVersion       = "tls-version"
+	KeyTLSCipherSuite   = "tls-cipher-suite"
+	KeyTLSALPN          = "tls-alpn"
+	KeyTLSServerName    = "tls-sni"
+	KeyTLSResumed       = "tls-resumed"
+	KeyTLSPeerSubject   = "tls-peer-subject"
+	KeyTLSHandshakeTime = "tls-handshake-time"
+	KeyTLSFailure       = "tls-failure"
+	KeyTLSAlert         = "tls-alert"
+	KeyTLSAlertBy       = "tls-alert-by"
+	KeyCertificates     = "certificates"
+)
+
+const (
+	tlsRecordAlert = 21
+)
+
+var tlsAlertNames = map[byte]string{
+	0:   "close notify",
+	10:  "unexpected message",
+	20:  "bad record MAC",
+	22:  "record overflow",
+	40:  "handshake failure",
+	42:  "bad certificate",
+	43:  "unsupported certificate",
+	44:  "revoked certificate",
+	45:  "expired certificate",
+	46:  "unknown certificate",
+	47:  "illegal parameter",
+	48:  "unknown certificate authority",
+	49:  "access denied",
+	50:  "error decoding message",
+	51:  "error decrypting message",
+	70:  "protocol version not supported",
+	71:  "insufficient security level",
+	80:  "internal error",
+	86:  "inappropriate fallback",
+	90:  "user canceled",
+	109: "missing extension",
+	110: "unsupported extension",
+	112: "unrecognized name",
+	116: "certificate required",
+	120: "no application protocol",
+}
+
+// MonitoredTLSConn monitors handshake of c, it is done on first
+// Read/Write or explicitly with Handshake as with tls.Conn
+func MonitoredTLSConn(c *tls.Conn, ctx context.Context) *TLSConn {
+	return &TLSConn{
+		Conn: c,
+		et:   gomon.FromContext(ctx),
+	}
+}
+
+func (c *TLSConn) Handshake() error {
+	return c.HandshakeContext(context.Background())
+}
+
+func (c *TLSConn) HandshakeContext(ctx context.Context) error {
+	c.once.Do(func() {
+		et := c.et.NewChild(false)
+		et.SetFingerprint("net-tls-handshake")
+		defer et.Finish()
+
+		start := time.Now()
+		err := c.Conn.HandshakeContext(ctx)
+		et.Set(KeyTLSHandshakeTime, time.Since(start))
+		if err != nil {
+			tlsHandshakeError(et, err)
+			return
+		}
+		fillTLSState(et, c.Conn.ConnectionState())
+	})
+	// result of the handshake is kept by tls.Conn
+	return c.Conn.HandshakeContext(ctx)
+}
+
+func (c *TLSConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *TLSConn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// MonitoredTLSListener is tls.NewListener over MonitoredListener,
+// accepted connections are *tls.Conn. Certificates of config are
+// reported periodically with their expiry
+func MonitoredTLSListener(inner net.Listener, config *tls.Config) net.Listener {
+	ml := MonitoredListener(inner)
+	l := &tlsListener{
+		Listener: ml,
+		et:       ml.(*wrappedListener).et,
+		certs:    make(map[string]*x509.Certificate),
+		stop:     make(chan struct{}),
+	}
+
+	base := config.Clone()
+	for i := range base.Certificates {
+		l.addCertificate(&base.Certificates[i])
+	}
+	if getCertificate := base.GetCertificate; getCertificate != nil {
+		base.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := getCertificate(hello)
+			if cert != nil {
+				l.addCertificate(cert)
+			}
+			return cert, err
+		}
+	}
+	getConfigForClient := base.GetConfigForClient
+	base.GetConfigForClient = nil
+	l.config = base.Clone()
+	l.config.GetConfigForClient = l.configForClient(base, getConfigForClient)
+
+	l.reportCertificates()
+	if interval := loadConfig().TLSCertReportInterval; interval > 0 {
+		go l.run(interval)
+	}
+	return l
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if wnc := unwrapNetConn(c); wnc != nil {
+		wnc.handshake = &tlsHandshake{}
+	}
+	return tls.Server(c, l.config), nil
+}
+
+func (l *tlsListener) Close() error {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+	return l.Listener.Close()
+}
+
+// configForClient returns per connection config, so that
+// VerifyConnection knows which connection finished handshake
+func (l *tlsListener) configForClient(base *tls.Config, f func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base
+		if f != nil {
+			c, err := f(hello)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				config = c
+			}
+		}
+
+		wnc := unwrapNetConn(hello.Conn)
+		if wnc == nil || wnc.handshake == nil {
+			return config, nil
+		}
+		wnc.handshake.started()
+
+		config = config.Clone()
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			wnc.handshake.complete(wnc.et, cs)
+			return nil
+		}
+		return config, nil
+	}
+}
+
+func (l *tlsListener) addCertificate(cert *tls.Certificate) {
+	leaf := cert.Leaf
+	if leaf == nil && len(cert.Certificate) > 0 {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return
+		}
+	}
+	if leaf == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.certs[hex.EncodeToString(leaf.SerialNumber.Bytes())] = leaf
+}
+
+func (l *tlsListener) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.reportCertificates()
+		}
+	}
+}
+
+func (l *tlsListener) reportCertificates() {
+	now := time.Now()
+	l.mu.Lock()
+	certs := make([]map[string]interface{}, 0, len(l.certs))
+	for serial, cert := range l.certs {
+		certs = append(certs, map[string]interface{}{
+			"serial":     serial,
+			"subject":    cert.Subject.String(),
+			"dns-names":  cert.DNSNames,
+			"not-after":  cert.NotAfter,
+			"expires-in": cert.NotAfter.Sub(now),
+		})
+	}
+	l.mu.Unlock()
+	if len(certs) == 0 {
+		return
+	}
+
+	et := l.et.NewChild(false)
+	et.SetFingerprint("net-tls-certificates")
+	et.Set(KeyCertificates, certs)
+	et.Finish()
+}
+
+func (h *tlsHandshake) started() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.start = time.Now()
+}
+
+func (h *tlsHandshake) complete(parent gomon.EventTracker, cs tls.ConnectionState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done = true
+	h.reported = true
+
+	et := parent.NewChild(false)
+	et.SetFingerprint("net-tls-handshake")
+	et.Set(KeyTLSHandshakeTime, time.Since(h.start))
+	fillTLSState(et, cs)
+	et.Finish()
+}
+
+// observe raw bytes of a connection until handshake is complete
+func (h *tlsHandshake) observe(remote bool, b []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done || len(h.alert) > 0 {
+		return
+	}
+
+	p, by := &h.out, "local"
+	if remote {
+		p, by = &h.in, "remote"
+	}
+	if alert, ok := p.feed(b); ok {
+		h.alert = alert
+		h.alertBy = by
+	}
+}
+
+// closed reports failed handshake, connections which
+// did not send anything are not reported
+func (h *tlsHandshake) closed(parent gomon.EventTracker, readBytes int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.reported || readBytes == 0 {
+		return
+	}
+	h.reported = true
+
+	et := parent.NewChild(false)
+	et.SetFingerprint("net-tls-handshake")
+	if h.start.IsZero() {
+		et.Set(KeyTLSFailure, "no-client-hello")
+	} else {
+		et.Set(KeyTLSFailure, "handshake")
+		et.Set(KeyTLSHandshakeTime, time.Since(h.start))
+	}
+	if len(h.alert) > 0 {
+		et.Set(KeyTLSAlert, h.alert)
+		et.Set(KeyTLSAlertBy, h.alertBy)
+	}
+	et.Finish()
+}
+
+// feed returns alert description when plaintext alert record is found,
+// alerts of TLS 1.3 after ServerHello are encrypted and are not visible
+func (p *tlsRecordParser) feed(b []byte) (alert string, ok bool) {
+	for len(b) > 0 {
+		if p.left == 0 {
+			k := copy(p.hdr[p.hdrLen:], b)
+			p.hdrLen += k
+			b = b[k:]
+			if p.hdrLen < len(p.hdr) {
+				return
+			}
+			p.left = int(p.hdr[3])<<8 | int(p.hdr[4])
+			p.payload = p.payload[:0]
+			if p.left == 0 {
+				p.hdrLen = 0
+				continue
+			}
+		}
+
+		k := min(p.left, len(b))
+		if p.hdr[0] == tlsRecordAlert && len(p.payload) < 2 {
+			p.payload = append(p.payload, b[:min(k, 2-len(p.payload))]...)
+		}
+		p.left -= k
+		b = b[k:]
+		if p.left > 0 {
+			return
+		}
+
+		p.hdrLen = 0
+		if p.hdr[0] != tlsRecordAlert {
+			continue
+		}
+		if length := int(p.hdr[3])<<8 | int(p.hdr[4]); length != 2 {
+			return "encrypted", true
+		}
+		if name, ok := tlsAlertNames[p.payload[1]]; ok {
+			return name, true
+		}
+		return "unknown", true
+	}
+	return
+}
+
+func fillTLSState(et gomon.EventTracker, cs tls.ConnectionState) {
+	et.Set(KeyTLSVersion, tls.VersionName(cs.Version))
+	et.Set(KeyTLSCipherSuite, tls.CipherSuiteName(cs.CipherSuite))
+	if len(cs.NegotiatedProtocol) > 0 {
+		et.Set(KeyTLSALPN, cs.NegotiatedProtocol)
+	}
+	if len(cs.ServerName) > 0 {
+		et.Set(KeyTLSServerName, cs.ServerName)
+	}
+	et.Set(KeyTLSResumed, cs.DidResume)
+	if len(cs.PeerCertificates) > 0 {
+		et.Set(KeyTLSPeerSubject, cs.PeerCertificates[0].Subject.String())
+	}
+}
+
+func tlsHandshakeError(et gomon.EventTracker, err error) {
+	et.AddError(err)
+
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var alertErr tls.AlertError
+	msg := err.Error()
+	switch {
+	case errors.As(err, &recordErr):
+		et.Set(KeyTLSFailure, "not-tls")
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalid), errors.As(err, &hostname):
+		et.Set(KeyTLSFailure, "certificate")
+	case errors.As(err, &alertErr):
+		et.Set(KeyTLSFailure, "alert")
+		et.Set(KeyTLSAlert, alertErr.Error())
+		et.Set(KeyTLSAlertBy, "local")
+	case strings.HasPrefix(msg, "remote error: tls: "):
+		et.Set(KeyTLSFailure, "alert")
+		et.Set(KeyTLSAlert, strings.TrimPrefix(msg, "remote error: tls: "))
+		et.Set(KeyTLSAlertBy, "remote")
+	default:
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			et.Set(KeyTLSFailure, "timeout")
+		} else {
+			et.Set(KeyTLSFailure, "handshake")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package net
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// selfSigned returns certificate for example.test and pool trusting it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(4242),
+		Subject:               pkix.Name{CommonName: "example.test"},
+		DNSNames:              []string{"example.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// serveTLS accepts a single connection of MonitoredTLSListener and
+// returns its event tracker once server side handshake is done
+func serveTLS(t *testing.T, l net.Listener) <-chan gomon.EventTracker {
+	accepted := make(chan gomon.EventTracker, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			close(accepted)
+			return
+		}
+		tc := c.(*tls.Conn)
+		tc.Handshake()
+		accepted <- tc.NetConn().(*wrappedNetConn).et
+		tc.Close()
+	}()
+	return accepted
+}
+
+func TestTLSListenerHandshake(t *testing.T) {
+	cert, pool := selfSigned(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := MonitoredTLSListener(inner, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	})
+	defer l.Close()
+	accepted := serveTLS(t, l)
+
+	c, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		RootCAs:    pool,
+		ServerName: "example.test",
+		NextProtos: []string{"h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	conn := <-accepted
+	handshake := events.wait(t, "net-tls-handshake", 1, childOf(conn))[0]
+	if handshake.Get(KeyTLSVersion) != "TLS 1.3" || handshake.Get(KeyTLSALPN) != "h2" ||
+		handshake.Get(KeyTLSServerName) != "example.test" || handshake.Get(KeyTLSResumed) != false {
+		t.Errorf("handshake version %v, alpn %v, sni %v, resumed %v", handshake.Get(KeyTLSVersion),
+			handshake.Get(KeyTLSALPN), handshake.Get(KeyTLSServerName), handshake.Get(KeyTLSResumed))
+	}
+	if handshake.Get(KeyTLSFailure) != nil {
+		t.Errorf("handshake failure = %v", handshake.Get(KeyTLSFailure))
+	}
+
+	report := events.wait(t, "net-tls-certificates", 1, childOf(l.(*tlsListener).et))[0]
+	certs := report.Get(KeyCertificates).([]map[string]interface{})
+	if len(certs) != 1 || certs[0]["serial"] != "1092" || certs[0]["subject"] != "CN=example.test" {
+		t.Errorf("certificates = %v", certs)
+	}
+}
+
+func TestTLSListenerNotTLS(t *testing.T) {
+	cert, _ := selfSigned(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := MonitoredTLSListener(inner, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer l.Close()
+	accepted := serveTLS(t, l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+
+	conn := <-accepted
+	handshake := events.wait(t, "net-tls-handshake", 1, childOf(conn))[0]
+	if handshake.Get(KeyTLSFailure) != "no-client-hello" {
+		t.Errorf("handshake failure = %v", handshake.Get(KeyTLSFailure))
+	}
+}
+
+func TestTLSConnFailures(t *testing.T) {
+	cert, _ := selfSigned(t)
+	tlsInner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsListener := tls.NewListener(tlsInner, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer tlsListener.Close()
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+
+	go func() {
+		for {
+			c, err := tlsListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}()
+		}
+	}()
+	go func() {
+		for {
+			c, err := plain.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n"))
+			c.Close()
+		}
+	}()
+
+	cases := []struct {
+		addr    string
+		failure string
+	}{
+		// certificate is not trusted by the client
+		{tlsListener.Addr().String(), "certificate"},
+		{plain.Addr().String(), "not-tls"},
+	}
+	for _, c := range cases {
+		raw, err := net.Dial("tcp", c.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := gomon.FromContext(nil).NewChild(false)
+		tc := MonitoredTLSConn(tls.Client(raw, &tls.Config{ServerName: "example.test"}),
+			gomon.WithContext(context.Background(), root))
+		if err := tc.Handshake(); err == nil {
+			t.Errorf("%s: handshake succeeded", c.failure)
+		}
+		tc.Close()
+
+		handshake := events.wait(t, "net-tls-handshake", 1, childOf(root))[0]
+		if handshake.Get(KeyTLSFailure) != c.failure {
+			t.Errorf("handshake failure = %v, want %s", handshake.Get(KeyTLSFailure), c.failure)
+		}
+		if errs, _ := handshake.Get(gomon.KeyErrors).([]error); len(errs) != 1 {
+			t.Errorf("%s: errors = %v", c.failure, errs)
+		}
+	}
+}
+
+func TestTLSRecordParserAlert(t *testing.T) {
+	// alert record: fatal handshake failure
+	record := []byte{tlsRecordAlert, 3, 3, 0, 2, 2, 40}
+	var p tlsRecordParser
+	// application data record before the alert
+	if _, ok := p.feed([]byte{23, 3, 3, 0, 3, 1, 2, 3}); ok {
+		t.Fatal("alert found in application data")
+	}
+	for i, b := range record {
+		alert, ok := p.feed([]byte{b})
+		if ok != (i == len(record)-1) {
+			t.Fatalf("byte %d: alert found = %v", i, ok)
+		}
+		if ok && alert != "handshake failure" {
+			t.Errorf("alert = %q", alert)
+		}
+	}
+}
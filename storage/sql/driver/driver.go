@@ -6,30 +6,66 @@ import (
 	"database/sql/driver"
 	"errors"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/iahmedov/gomon"
 )
 
+func init() {
+	gomon.SetConfigFunc(pluginName, SetConfig)
+}
+
 type PluginConfig struct {
 	MaxRows  int
 	QueryLen int
+	// raw query text may contain literal values, set it
+	// to keep only normalized form on events
+	HideRawQuery bool
+
+	// aggregates statistics of executed queries, nil disables it
+	Profile *Profile
+	// application frames kept for every caller of a query
+	// in Profile, zero disables caller stacks
+	ProfileStackDepth int
+
+	// queries running longer are reported with "sql-slow-query"
+	// event, zero disables it
+	SlowQueryThreshold time.Duration
+	// returns value of bound parameter shown in slow query events,
+	// by default only type (and length) of values are shown
+	RedactParam func(arg driver.NamedValue) interface{}
+	// executed for slow queries on a separate connection,
+	// its output is added to slow query event, see Explain
+	Explain ExplainFunc
 }
 
 type wrappedDriver struct {
 	parent driver.Driver
 	c      *PluginConfig
+
+	mu         sync.Mutex
+	explainers map[string]*explainer
 }
 
 type wrappedConn struct {
 	parent driver.Conn
 	c      *PluginConfig
 	et     gomon.EventTracker
+
+	// driver and name connection was opened with
+	d    *wrappedDriver
+	name string
 }
 
 type wrappedRows struct {
 	parent driver.Rows
 	c      *PluginConfig
 	et     gomon.EventTracker
+
+	count   int64
+	profile *profileSample
 }
 
 type wrappedStmt struct {
@@ -42,6 +78,8 @@ type wrappedStmt struct {
 	parent driver.Stmt
 	c      *PluginConfig
 	et     gomon.EventTracker
+	query  *queryInfo
+	conn   *wrappedConn
 }
 
 type wrappedTx struct {
@@ -83,22 +121,57 @@ var _ driver.Result = (*wrappedResult)(nil)
 var defaultConfig = &PluginConfig{
 	MaxRows:  10,
 	QueryLen: 1024,
+
+	ProfileStackDepth: 8,
 }
 
+// config set by SetConfig, it is loaded once per connection
+var currentConfig atomic.Value // *PluginConfig
+
 var (
 	pluginName     = "gomon/sql"
+	timingName     = "db"
 	KeyQuery       = "query"
 	KeyParams      = "params"
 	KeyNamedParams = "named_params"
 )
 
+func SetConfig(conf gomon.TrackerConfig) {
+	if c, ok := conf.(*PluginConfig); ok {
+		currentConfig.Store(c)
+	} else {
+		panic("setting not compatible config")
+	}
+}
+
+// loadConfig returns config set by SetConfig, config
+// must not be modified after it is set
+func loadConfig() *PluginConfig {
+	if c, ok := currentConfig.Load().(*PluginConfig); ok {
+		return c
+	}
+	return defaultConfig
+}
+
+func (p *PluginConfig) Name() string {
+	return pluginName
+}
+
+// MonitoredDriver wraps d, connections opened after SetConfig use new config
 func MonitoredDriver(d driver.Driver) driver.Driver {
 	return &wrappedDriver{
 		parent: d,
-		c:      defaultConfig,
 	}
 }
 
+// drivers without own config follow SetConfig
+func (wdr *wrappedDriver) pluginConfig() *PluginConfig {
+	if wdr.c == nil {
+		return loadConfig()
+	}
+	return wdr.c
+}
+
 func AutoRegister() {
 	for _, driver := range sql.Drivers() {
 		if strings.HasPrefix(driver, "monitored-") {
@@ -127,8 +200,10 @@ func (wdr *wrappedDriver) Open(name string) (conn driver.Conn, err error) {
 		et.SetFingerprint("sql-wconn")
 		conn = &wrappedConn{
 			parent: conn,
-			c:      wdr.c,
+			c:      wdr.pluginConfig(),
 			et:     et,
+			d:      wdr,
+			name:   name,
 		}
 	} else {
 		conn = nil
@@ -149,11 +224,16 @@ func (wcn *wrappedConn) Ping(ctx context.Context) (err error) {
 func (wcn *wrappedConn) Query(query string, args []driver.Value) (rows driver.Rows, err error) {
 	et := wcn.et.NewChild(false)
 	et.SetFingerprint("sql-wconn-query")
+	info := newQueryInfo(query)
+	info.fill(et, wcn.c)
+	profile := wcn.c.startProfile(info)
 	defer func() {
 		if err != nil {
 			et.AddError(err)
 		}
 		et.Finish()
+		profile.done(et.Lapsed(), err)
+		wcn.slowQueryValues(et, info, args, err)
 	}()
 	if queryer, ok := wcn.parent.(driver.Queryer); ok {
 		rows, err = queryer.Query(query, args)
@@ -165,9 +245,10 @@ func (wcn *wrappedConn) Query(query string, args []driver.Value) (rows driver.Ro
 		et := et.NewChild(false)
 		et.SetFingerprint("sql-wrows")
 		rows = &wrappedRows{
-			parent: rows,
-			c:      wcn.c,
-			et:     et,
+			parent:  rows,
+			c:       wcn.c,
+			et:      et,
+			profile: profile,
 		}
 	} else {
 		rows = nil
@@ -179,12 +260,17 @@ func (wcn *wrappedConn) Query(query string, args []driver.Value) (rows driver.Ro
 func (wcn *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
 	et := wcn.et.NewChild(false)
 	et.SetFingerprint("sql-wconn-queryctx")
-	et.Set("query", query)
+	info := newQueryInfo(query)
+	info.fill(et, wcn.c)
+	profile := wcn.c.startProfile(info)
 	defer func() {
 		if err != nil {
 			et.AddError(err)
 		}
 		et.Finish()
+		gomon.AddTiming(ctx, timingName, et.Lapsed())
+		profile.done(et.Lapsed(), err)
+		wcn.slowQuery(et, info, args, err)
 	}()
 	if queryer, ok := wcn.parent.(driver.QueryerContext); ok {
 		rows, err = queryer.QueryContext(ctx, query, args)
@@ -196,9 +282,10 @@ func (wcn *wrappedConn) QueryContext(ctx context.Context, query string, args []d
 		et := et.NewChild(false)
 		et.SetFingerprint("sql-wrows")
 		rows = &wrappedRows{
-			parent: rows,
-			c:      wcn.c,
-			et:     et,
+			parent:  rows,
+			c:       wcn.c,
+			et:      et,
+			profile: profile,
 		}
 	} else {
 		rows = nil
@@ -214,7 +301,8 @@ func (wcn *wrappedConn) Prepare(query string) (stmt driver.Stmt, err error) {
 func (wcn *wrappedConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
 	et := wcn.et.NewChild(false)
 	et.SetFingerprint("conn-prepare")
-	et.Set("query", query)
+	info := newQueryInfo(query)
+	info.fill(et, wcn.c)
 
 	defer func() {
 		if err != nil {
@@ -233,6 +321,8 @@ func (wcn *wrappedConn) PrepareContext(ctx context.Context, query string) (stmt
 		parent: stmt,
 		c:      wcn.c,
 		et:     et,
+		query:  info,
+		conn:   wcn,
 	}
 	return
 }
@@ -290,6 +380,7 @@ func (wrs *wrappedRows) Columns() (cols []string) {
 
 func (wrs *wrappedRows) Close() (err error) {
 	err = wrs.parent.Close()
+	wrs.profile.returned(wrs.count)
 	if err != nil {
 		et := wrs.et.NewChild(true)
 		et.SetFingerprint("sql-wrows-close")
@@ -308,6 +399,7 @@ func (wrs *wrappedRows) Next(dest []driver.Value) (err error) {
 		et.AddError(err)
 		et.Finish()
 	} else {
+		wrs.count++
 		v := wrs.et.Get("rows")
 		var rows [][]driver.Value
 		if v == nil {
@@ -364,6 +456,12 @@ func (wst *wrappedStmt) Close() (err error) {
 	return
 }
 
+// statement's raw query is on its prepare tracker
+func (wst *wrappedStmt) setQuery(et gomon.EventTracker) {
+	et.Set(KeyNormalizedQuery, truncate(wst.query.normalized, wst.c.QueryLen))
+	et.Set(KeyQueryFingerprint, wst.query.fingerprint)
+}
+
 func (wst *wrappedStmt) NumInput() int {
 	return wst.parent.NumInput()
 }
@@ -371,35 +469,27 @@ func (wst *wrappedStmt) NumInput() int {
 func (wst *wrappedStmt) Exec(args []driver.Value) (res driver.Result, err error) {
 	et := wst.et.NewChild(false)
 	et.SetFingerprint("sql-wstmt-exec")
+	wst.setQuery(et)
+	profile := wst.c.startProfile(wst.query)
 
 	res, err = wst.parent.Exec(args)
 
 	if err != nil {
 		et.AddError(err)
 	} else {
-		// TODO: should we populate data here?
-		// make it configurable
-		lid, errl := res.LastInsertId()
-		if errl != nil {
-			et.Set("last-id", lid)
-		} else {
-			et.Set("last-id-err", errl)
-		}
-
-		raf, errf := res.RowsAffected()
-		if errf != nil {
-			et.Set("rows-aff", raf)
-		} else {
-			et.Set("rows-aff-err", errf)
-		}
+		fillResult(et, res, profile)
 	}
 	et.Finish()
+	profile.done(et.Lapsed(), err)
+	wst.conn.slowQueryValues(et, wst.query, args, err)
 	return
 }
 
 func (wst *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
 	et := wst.et.NewChild(false)
 	et.SetFingerprint("sql-wstmt-execctx")
+	wst.setQuery(et)
+	profile := wst.c.startProfile(wst.query)
 
 	if parentExecCtx, ok := wst.parent.(driver.StmtExecContext); ok {
 		res, err = parentExecCtx.ExecContext(ctx, args)
@@ -415,33 +505,47 @@ func (wst *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValu
 	if err != nil {
 		et.AddError(err)
 	} else {
-		// TODO: should we populate data here?
-		// make it configurable
-		lid, errl := res.LastInsertId()
-		if errl != nil {
-			et.Set("last-id", lid)
-		} else {
-			et.Set("last-id-err", errl)
-		}
-
-		raf, errf := res.RowsAffected()
-		if errf != nil {
-			et.Set("rows-aff", raf)
-		} else {
-			et.Set("rows-aff-err", errf)
-		}
+		fillResult(et, res, profile)
 	}
 	et.Finish()
+	profile.done(et.Lapsed(), err)
+	wst.conn.slowQuery(et, wst.query, args, err)
+	gomon.AddTiming(ctx, timingName, et.Lapsed())
 	return
 }
 
+func fillResult(et gomon.EventTracker, res driver.Result, profile *profileSample) {
+	// TODO: should we populate data here?
+	// make it configurable
+	lid, errl := res.LastInsertId()
+	if errl == nil {
+		et.Set("last-id", lid)
+	} else {
+		et.Set("last-id-err", errl)
+	}
+
+	raf, errf := res.RowsAffected()
+	if errf == nil {
+		et.Set("rows-aff", raf)
+		profile.affected(raf)
+	} else {
+		et.Set("rows-aff-err", errf)
+	}
+}
+
 func (wst *wrappedStmt) Query(args []driver.Value) (rows driver.Rows, err error) {
 	et := wst.et.NewChild(false)
 	et.SetFingerprint("sql-wstmt-query")
+	wst.setQuery(et)
+	profile := wst.c.startProfile(wst.query)
 	// NOTE: this creates double entry in database
 	// 1. for query execution time
 	// 2. after rows.Close() called
-	defer et.Finish()
+	defer func() {
+		et.Finish()
+		profile.done(et.Lapsed(), err)
+		wst.conn.slowQueryValues(et, wst.query, args, err)
+	}()
 
 	rows, err = wst.parent.Query(args)
 
@@ -449,9 +553,10 @@ func (wst *wrappedStmt) Query(args []driver.Value) (rows driver.Rows, err error)
 		et.AddError(err)
 	} else {
 		rows = &wrappedRows{
-			parent: rows,
-			c:      wst.c,
-			et:     et,
+			parent:  rows,
+			c:       wst.c,
+			et:      et,
+			profile: profile,
 		}
 	}
 	return
@@ -460,11 +565,18 @@ func (wst *wrappedStmt) Query(args []driver.Value) (rows driver.Rows, err error)
 func (wst *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
 	et := wst.et.NewChild(false)
 	et.SetFingerprint("sql-wstmt-queryctx")
+	wst.setQuery(et)
+	profile := wst.c.startProfile(wst.query)
 
 	// NOTE: this creates double entry in database
 	// 1. for query execution time
 	// 2. after rows.Close() called (with data)
-	defer et.Finish()
+	defer func() {
+		et.Finish()
+		gomon.AddTiming(ctx, timingName, et.Lapsed())
+		profile.done(et.Lapsed(), err)
+		wst.conn.slowQuery(et, wst.query, args, err)
+	}()
 
 	if parentQueryCtx, ok := wst.parent.(driver.StmtQueryContext); ok {
 		rows, err = parentQueryCtx.QueryContext(ctx, args)
@@ -481,9 +593,10 @@ func (wst *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedVal
 		et.AddError(err)
 	} else {
 		rows = &wrappedRows{
-			parent: rows,
-			c:      wst.c,
-			et:     et,
+			parent:  rows,
+			c:       wst.c,
+			et:      et,
+			profile: profile,
 		}
 	}
 	return
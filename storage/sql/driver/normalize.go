@@ -0,0 +1,473 @@
+package driver
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/iahmedov/gomon"
+)
+
+// queryInfo is cached by query text (see queryCache), statements
+// keep it for their exec/query calls
+type queryInfo struct {
+	raw         string
+	normalized  string
+	fingerprint string
+}
+
+// queryCache keeps queryInfo of up to kMaxCachedQueries query
+// texts, it is cleared when full so generated queries (with
+// literals inlined) do not grow it without bound
+type queryCache struct {
+	mu      sync.Mutex
+	queries map[string]*queryInfo
+}
+
+var (
+	KeyNormalizedQuery  = "normalized-query"
+	KeyQueryFingerprint = "query-fingerprint"
+)
+
+const kMaxCachedQueries = 1024
+
+var queries = queryCache{queries: make(map[string]*queryInfo)}
+
+func newQueryInfo(query string) *queryInfo {
+	return queries.get(query)
+}
+
+func (c *queryCache) get(query string) *queryInfo {
+	c.mu.Lock()
+	q, ok := c.queries[query]
+	c.mu.Unlock()
+	if ok {
+		return q
+	}
+
+	normalized := Normalize(query)
+	q = &queryInfo{
+		raw:         query,
+		normalized:  normalized,
+		fingerprint: fingerprint(normalized),
+	}
+
+	c.mu.Lock()
+	if len(c.queries) >= kMaxCachedQueries {
+		c.queries = make(map[string]*queryInfo)
+	}
+	c.queries[query] = q
+	c.mu.Unlock()
+	return q
+}
+
+// fill sets query information on et, raw text unless HideRawQuery
+// is set. Query texts are truncated to QueryLen (zero means no limit)
+func (q *queryInfo) fill(et gomon.EventTracker, c *PluginConfig) {
+	if !c.HideRawQuery {
+		et.Set(KeyQuery, truncate(q.raw, c.QueryLen))
+	}
+	q.fillNormalized(et, c)
+}
+
+// fillNormalized sets query information without raw text
+func (q *queryInfo) fillNormalized(et gomon.EventTracker, c *PluginConfig) {
+	et.Set(KeyNormalizedQuery, truncate(q.normalized, c.QueryLen))
+	et.Set(KeyQueryFingerprint, q.fingerprint)
+}
+
+// truncate cuts s to at most n bytes without splitting a rune
+func truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Normalize returns query with literals replaced by "?", comments
+// stripped, unquoted words lowercased and whitespace collapsed. Bind
+// parameters ($1, :name) become "?" as well, IN-lists collapse to
+// "in (?+)" and multi-row VALUES lists keep only the first row, so
+// queries differing only in values have the same form
+func Normalize(query string) string {
+	tokens := tokenize(query)
+	tokens = collapseInLists(tokens)
+	tokens = collapseValuesRows(tokens)
+
+	var b strings.Builder
+	b.Grow(len(query))
+	for i, t := range tokens {
+		if i > 0 && spaceBetween(tokens[i-1], t) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// Fingerprint returns stable hash of the normalized query
+func Fingerprint(query string) string {
+	return fingerprint(Normalize(query))
+}
+
+func fingerprint(normalized string) string {
+	h := fnv.New64a()
+	h.Write([]byte(normalized))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenValue
+	tokenPunct
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var valueToken = token{tokenValue, "?"}
+
+func tokenize(q string) (tokens []token) {
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && strings.HasPrefix(q[i:], "--"), c == '#' && (i+1 == len(q) || q[i+1] == ' '):
+			// line comment, "# " is mysql only (#> is postgres operator)
+			for i < len(q) && q[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(q[i:], "/*"):
+			if end := strings.Index(q[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(q)
+			}
+		case c == '\'':
+			i = skipQuoted(q, i, '\'')
+			tokens = append(tokens, valueToken)
+		case c == '"' || c == '`':
+			// quoted identifiers are case sensitive
+			end := skipQuoted(q, i, c)
+			tokens = append(tokens, token{tokenWord, q[i:end]})
+			i = end
+		case c == '[' && isIdentStart(next(q, i+1)):
+			// sql server quoted identifier
+			end := strings.IndexByte(q[i:], ']')
+			if end < 0 {
+				end = len(q) - i - 1
+			}
+			tokens = append(tokens, token{tokenWord, q[i : i+end+1]})
+			i += end + 1
+		case c == '$' && isDigit(next(q, i+1)):
+			// postgres bind parameter
+			i = skipDigits(q, i+1)
+			tokens = append(tokens, valueToken)
+		case c == '$':
+			if end, ok := skipDollarQuoted(q, i); ok {
+				tokens = append(tokens, valueToken)
+				i = end
+			} else {
+				tokens = append(tokens, token{tokenOperator, "$"})
+				i++
+			}
+		case c == '?':
+			i++
+			tokens = append(tokens, valueToken)
+		case c == ':' && isIdentStart(next(q, i+1)) && !(i > 0 && q[i-1] == ':'):
+			// named bind parameter, but not a postgres cast (::int)
+			i = skipIdent(q, i+1)
+			tokens = append(tokens, valueToken)
+		case isDigit(c) || c == '.' && isDigit(next(q, i+1)):
+			i = skipNumber(q, i)
+			if isSign(tokens) {
+				tokens = tokens[:len(tokens)-1]
+			}
+			tokens = append(tokens, valueToken)
+		case c == '@' || isIdentStart(c):
+			end := skipIdent(q, i+1)
+			word := q[i:end]
+			if c != '@' {
+				word = strings.ToLower(word)
+			}
+			if (word == "x" || word == "b" || word == "n" || word == "e") && next(q, end) == '\'' {
+				// x'ff', b'01', N'text', E'escaped'
+				end = skipQuoted(q, end, '\'')
+				tokens = append(tokens, valueToken)
+			} else {
+				tokens = append(tokens, token{tokenWord, word})
+			}
+			i = end
+		case c == '(' || c == ')' || c == ',' || c == ';' || c == '.' || c == '[' || c == ']':
+			tokens = append(tokens, token{tokenPunct, string(c)})
+			i++
+		case c < utf8.RuneSelf:
+			end := i + 1
+			for end < len(q) && strings.IndexByte(operatorChars, q[end]) >= 0 &&
+				!strings.HasPrefix(q[end:], "--") && !strings.HasPrefix(q[end:], "/*") &&
+				!isNumberSign(q, end) {
+				end++
+			}
+			tokens = append(tokens, token{tokenOperator, q[i:end]})
+			i = end
+		default:
+			// non ascii identifier
+			end := skipIdent(q, i)
+			if end == i {
+				_, size := utf8.DecodeRuneInString(q[i:])
+				end = i + size
+			}
+			tokens = append(tokens, token{tokenWord, strings.ToLower(q[i:end])})
+			i = end
+		}
+	}
+
+	// trailing semicolons do not change the statement
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return
+}
+
+const operatorChars = "=<>!|&+-*/%^~:"
+
+// isNumberSign reports whether q[i] is sign of a number, so
+// that "=-1" is tokenized as "=" and "-1"
+func isNumberSign(q string, i int) bool {
+	if q[i] != '-' && q[i] != '+' {
+		return false
+	}
+	c := next(q, i+1)
+	return isDigit(c) || c == '.' && isDigit(next(q, i+2))
+}
+
+// isSign reports whether last token is unary minus/plus of a number
+func isSign(tokens []token) bool {
+	n := len(tokens)
+	if n == 0 || tokens[n-1].kind != tokenOperator {
+		return false
+	}
+	if t := tokens[n-1].text; t != "-" && t != "+" {
+		return false
+	}
+	if n == 1 {
+		return true
+	}
+	prev := tokens[n-2]
+	return prev.kind == tokenOperator || prev.kind == tokenPunct && prev.text != ")" && prev.text != "]" ||
+		prev.kind == tokenWord && signKeywords[prev.text]
+}
+
+var signKeywords = map[string]bool{
+	"select": true, "where": true, "and": true, "or": true, "not": true,
+	"values": true, "set": true, "then": true, "else": true, "when": true,
+	"limit": true, "offset": true, "between": true, "in": true, "return": true,
+}
+
+// collapseInLists replaces "in (?, ?, ...)" with "in (?+)"
+func collapseInLists(tokens []token) []token {
+	out := tokens[:0]
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if tokens[i].kind != tokenWord || tokens[i].text != "in" ||
+			i+2 >= len(tokens) || tokens[i+1].text != "(" {
+			continue
+		}
+
+		// values separated with commas up to closing paren
+		j, values := i+2, 0
+		for j < len(tokens) && tokens[j].kind == tokenValue {
+			values++
+			j++
+			if j < len(tokens) && tokens[j].text == "," {
+				j++
+			} else {
+				break
+			}
+		}
+		if values > 0 && j < len(tokens) && tokens[j].text == ")" && tokens[j-1].kind == tokenValue {
+			out = append(out, token{tokenPunct, "("}, token{tokenValue, "?+"}, token{tokenPunct, ")"})
+			i = j
+		}
+	}
+	return out
+}
+
+// collapseValuesRows replaces "values (?, ?), (?, ?)" with
+// "values (?, ?)", rows with expressions are kept as is
+func collapseValuesRows(tokens []token) []token {
+	out := tokens[:0]
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if tokens[i].kind != tokenWord || tokens[i].text != "values" {
+			continue
+		}
+
+		end := valuesRow(tokens, i+1)
+		if end < 0 {
+			continue
+		}
+		out = append(out, tokens[i+1:end]...)
+		i = end - 1
+		// following rows separated with commas are dropped
+		for i+1 < len(tokens) && tokens[i+1].text == "," {
+			next := valuesRow(tokens, i+2)
+			if next < 0 {
+				break
+			}
+			i = next - 1
+		}
+	}
+	return out
+}
+
+// valuesRow returns index after "(?, ?, ...)" starting at i, -1 if
+// tokens at i are not a parenthesized list of values
+func valuesRow(tokens []token, i int) int {
+	if i >= len(tokens) || tokens[i].text != "(" {
+		return -1
+	}
+	for j := i + 1; j+1 < len(tokens) && tokens[j].kind == tokenValue; j += 2 {
+		switch tokens[j+1].text {
+		case ")":
+			return j + 2
+		case ",":
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+func spaceBetween(prev, cur token) bool {
+	switch {
+	case prev.text == "(" || prev.text == "." || prev.text == "[":
+		return false
+	case cur.text == ")" || cur.text == "," || cur.text == "." || cur.text == ";" || cur.text == "]":
+		return false
+	case cur.text == "(" && prev.kind == tokenWord && !parenKeywords[prev.text]:
+		// function call
+		return false
+	case prev.text == "::" || cur.text == "::":
+		return false
+	}
+	return true
+}
+
+// keywords followed by space before parenthesis
+var parenKeywords = map[string]bool{
+	"in": true, "values": true, "and": true, "or": true, "not": true,
+	"on": true, "from": true, "join": true, "where": true, "exists": true,
+	"as": true, "select": true, "into": true, "using": true, "set": true,
+	"when": true, "then": true, "else": true, "union": true, "all": true,
+	"any": true, "some": true, "with": true, "over": true, "by": true,
+}
+
+func next(q string, i int) byte {
+	if i < len(q) {
+		return q[i]
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func skipDigits(q string, i int) int {
+	for i < len(q) && isDigit(q[i]) {
+		i++
+	}
+	return i
+}
+
+func skipIdent(q string, i int) int {
+	for i < len(q) {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		if r != '_' && r != '$' && r != '@' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// skipNumber skips integer, decimal, exponent and hex (0x1f) forms
+func skipNumber(q string, i int) int {
+	if q[i] == '0' && (next(q, i+1) == 'x' || next(q, i+1) == 'X') {
+		i += 2
+		for i < len(q) && strings.IndexByte("0123456789abcdefABCDEF", q[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+	i = skipDigits(q, i)
+	if next(q, i) == '.' {
+		i = skipDigits(q, i+1)
+	}
+	if c := next(q, i); c == 'e' || c == 'E' {
+		j := i + 1
+		if c := next(q, j); c == '+' || c == '-' {
+			j++
+		}
+		if isDigit(next(q, j)) {
+			i = skipDigits(q, j)
+		}
+	}
+	return i
+}
+
+// skipQuoted returns index after closing quote, doubled quote
+// and backslash escape are part of the literal
+func skipQuoted(q string, i int, quote byte) int {
+	for i++; i < len(q); i++ {
+		switch q[i] {
+		case '\\':
+			if quote == '\'' {
+				i++
+			}
+		case quote:
+			if next(q, i+1) != quote {
+				return i + 1
+			}
+			i++
+		}
+	}
+	return len(q)
+}
+
+// skipDollarQuoted skips postgres $tag$...$tag$ string
+func skipDollarQuoted(q string, i int) (int, bool) {
+	end := strings.IndexByte(q[i+1:], '$')
+	if end < 0 {
+		return i, false
+	}
+	tag := q[i : i+end+2]
+	for _, c := range tag[1 : len(tag)-1] {
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return i, false
+		}
+	}
+	body := i + len(tag)
+	close := strings.Index(q[body:], tag)
+	if close < 0 {
+		return len(q), true
+	}
+	return body + close + len(tag), true
+}
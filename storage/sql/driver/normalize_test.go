@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		query, normalized string
+	}{
+		{"SELECT * FROM users WHERE id = 42", "select * from users where id = ?"},
+		{"select *   from users\n\twhere id=7;", "select * from users where id = ?"},
+		{"SELECT a FROM t WHERE name = 'O''Brien' -- comment", "select a from t where name = ?"},
+		{"/* app:svc */ SELECT count(*) FROM t", "select count(*) from t"},
+		{"SELECT a FROM t WHERE id IN (1, 2, 3) AND x in ($1,$2)", "select a from t where id in (?+) and x in (?+)"},
+		{"INSERT INTO t (a, b) VALUES (?, ?), (?, ?), (?, ?)", "insert into t(a, b) values (?, ?)"},
+		{"insert into t values (1, 2)", "insert into t values (?, ?)"},
+		{"insert into t values (1, now()), (2, now())", "insert into t values (?, now()), (?, now())"},
+		{"select a=-1.5e3, b*-2, c-3, d - -4", "select a = ?, b * ?, c - ?, d - ?"},
+		{"SELECT x'ff', N'text', e'\\n', 0x1F, .5", "select ?, ?, ?, ?, ?"},
+		{"SELECT a::int FROM t WHERE b = :name AND j #> '{a}' = 'b'", "select a::int from t where b = ? and j #> ? = ?"},
+		{"SELECT $body$ it's $body$, \"Mixed\".Col FROM \"Mixed\"", "select ?, \"Mixed\".col from \"Mixed\""},
+		{"SELECT [Order].Id FROM [Order]", "select [Order].id from [Order]"},
+	}
+	for _, c := range cases {
+		if got := Normalize(c.query); got != c.normalized {
+			t.Errorf("Normalize(%q) = %q, want %q", c.query, got, c.normalized)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint("SELECT * FROM t WHERE id = 1")
+	b := Fingerprint("select * from t where id=2")
+	c := Fingerprint("select * from t where name = 'x'")
+	if a != b {
+		t.Errorf("queries differing in values have different fingerprints %s, %s", a, b)
+	}
+	if a == c {
+		t.Errorf("different queries have the same fingerprint %s", a)
+	}
+	if len(a) != 16 {
+		t.Errorf("fingerprint %q is not 16 hex digits", a)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"select", 0, "select"},
+		{"select", 10, "select"},
+		{"select", 3, "sel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"日本", 4, "日"},
+	}
+	for _, c := range cases {
+		got := truncate(c.s, c.n)
+		if got != c.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", c.s, c.n, got, c.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q is not valid utf-8", c.s, c.n, got)
+		}
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	q := newQueryInfo("select 1")
+	if newQueryInfo("select 1") != q {
+		t.Error("query info is not cached")
+	}
+
+	for i := 0; i < kMaxCachedQueries+1; i++ {
+		newQueryInfo("select " + strings.Repeat("a", i))
+	}
+	queries.mu.Lock()
+	n := len(queries.queries)
+	queries.mu.Unlock()
+	if n > kMaxCachedQueries {
+		t.Errorf("cache has %d queries, limit is %d", n, kMaxCachedQueries)
+	}
+}
@@ -0,0 +1,252 @@
+package driver
+
+import (
+	"compress/gzip"
+	"io"
+	"runtime"
+	"time"
+)
+
+// WriteProfile writes profile in pprof format (gzipped profile.proto),
+// so it can be viewed with `go tool pprof`. Every normalized query
+// is a leaf function named "sql: <query>", called by application
+// frames which executed it. Sample values are calls, total latency,
+// rows (returned and affected) and errors
+func (p *Profile) WriteProfile(w io.Writer) error {
+	b := newProfileBuilder()
+
+	p.mu.Lock()
+	start := p.start
+	for _, qs := range p.queries {
+		queryLoc := b.location(runtime.Frame{Function: "sql: " + qs.query, File: qs.fingerprint})
+		for _, cs := range qs.callers {
+			if cs.calls == 0 {
+				continue
+			}
+			locs := []uint64{queryLoc}
+			for _, f := range cs.frames {
+				locs = append(locs, b.location(f))
+			}
+			b.sample(locs, []int64{cs.calls, int64(cs.total), cs.rows, cs.errors}, qs.fingerprint)
+		}
+	}
+	p.mu.Unlock()
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.build(start, time.Now())); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// profileBuilder encodes profile.proto messages, see
+// https://github.com/google/pprof/blob/master/proto/profile.proto
+type profileBuilder struct {
+	strings   map[string]int64
+	table     []string
+	functions map[functionKey]uint64
+	locations map[locationKey]uint64
+
+	functionsBuf protoBuffer
+	locationsBuf protoBuffer
+	samplesBuf   protoBuffer
+}
+
+type functionKey struct {
+	name, file string
+}
+
+type locationKey struct {
+	function uint64
+	line     int
+}
+
+// profile.proto field numbers
+const (
+	profileSampleType    = 1
+	profileSamples       = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+	profileDefaultSample = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+	sampleLabel      = 3
+
+	labelKey = 1
+	labelStr = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
+
+var profileSampleTypes = [][2]string{
+	{"calls", "count"},
+	{"latency", "nanoseconds"},
+	{"rows", "count"},
+	{"errors", "count"},
+}
+
+func newProfileBuilder() *profileBuilder {
+	b := &profileBuilder{
+		strings:   make(map[string]int64),
+		functions: make(map[functionKey]uint64),
+		locations: make(map[locationKey]uint64),
+	}
+	// string_table[0] must be ""
+	b.str("")
+	return b
+}
+
+func (b *profileBuilder) str(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	i := int64(len(b.table))
+	b.strings[s] = i
+	b.table = append(b.table, s)
+	return i
+}
+
+func (b *profileBuilder) location(f runtime.Frame) uint64 {
+	fkey := functionKey{f.Function, f.File}
+	fid, ok := b.functions[fkey]
+	if !ok {
+		fid = uint64(len(b.functions) + 1)
+		b.functions[fkey] = fid
+
+		var fn protoBuffer
+		fn.uint64(functionID, fid)
+		fn.int64(functionName, b.str(f.Function))
+		fn.int64(functionSystemName, b.str(f.Function))
+		fn.int64(functionFilename, b.str(f.File))
+		b.functionsBuf.message(profileFunction, &fn)
+	}
+
+	lkey := locationKey{fid, f.Line}
+	lid, ok := b.locations[lkey]
+	if !ok {
+		lid = uint64(len(b.locations) + 1)
+		b.locations[lkey] = lid
+
+		var line, loc protoBuffer
+		line.uint64(lineFunctionID, fid)
+		line.int64(lineLine, int64(f.Line))
+		loc.uint64(locationID, lid)
+		loc.message(locationLine, &line)
+		b.locationsBuf.message(profileLocation, &loc)
+	}
+	return lid
+}
+
+func (b *profileBuilder) sample(locs []uint64, values []int64, fingerprint string) {
+	var s, label protoBuffer
+	s.packedUint64(sampleLocationID, locs)
+	s.packedInt64(sampleValue, values)
+	label.int64(labelKey, b.str("fingerprint"))
+	label.int64(labelStr, b.str(fingerprint))
+	s.message(sampleLabel, &label)
+	b.samplesBuf.message(profileSamples, &s)
+}
+
+func (b *profileBuilder) build(start, end time.Time) []byte {
+	var out protoBuffer
+	for _, st := range profileSampleTypes {
+		var vt protoBuffer
+		vt.int64(valueTypeType, b.str(st[0]))
+		vt.int64(valueTypeUnit, b.str(st[1]))
+		out.message(profileSampleType, &vt)
+	}
+	out.data = append(out.data, b.samplesBuf.data...)
+	out.data = append(out.data, b.locationsBuf.data...)
+	out.data = append(out.data, b.functionsBuf.data...)
+
+	// strings used below must be in the table before it is written
+	var period protoBuffer
+	period.int64(valueTypeType, b.str("calls"))
+	period.int64(valueTypeUnit, b.str("count"))
+	defaultSample := b.str("latency")
+
+	for _, s := range b.table {
+		out.string(profileStringTable, s)
+	}
+	out.int64(profileTimeNanos, start.UnixNano())
+	out.int64(profileDurationNanos, int64(end.Sub(start)))
+	out.message(profilePeriodType, &period)
+	out.int64(profilePeriod, 1)
+	out.int64(profileDefaultSample, defaultSample)
+	return out.data
+}
+
+// protoBuffer is minimal protobuf encoder
+type protoBuffer struct {
+	data []byte
+}
+
+func (p *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		p.data = append(p.data, byte(x)|0x80)
+		x >>= 7
+	}
+	p.data = append(p.data, byte(x))
+}
+
+func (p *protoBuffer) tag(field, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	p.tag(field, 0)
+	p.varint(x)
+}
+
+func (p *protoBuffer) int64(field int, x int64) {
+	p.uint64(field, uint64(x))
+}
+
+func (p *protoBuffer) string(field int, s string) {
+	p.tag(field, 2)
+	p.varint(uint64(len(s)))
+	p.data = append(p.data, s...)
+}
+
+func (p *protoBuffer) message(field int, m *protoBuffer) {
+	p.tag(field, 2)
+	p.varint(uint64(len(m.data)))
+	p.data = append(p.data, m.data...)
+}
+
+func (p *protoBuffer) packedUint64(field int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	p.message(field, &packed)
+}
+
+func (p *protoBuffer) packedInt64(field int, xs []int64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	p.message(field, &packed)
+}
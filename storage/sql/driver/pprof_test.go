@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testProfile() *Profile {
+	p := NewProfile(10)
+	q := newQueryInfo("SELECT * FROM t WHERE id = 1")
+	frames := []runtime.Frame{
+		{PC: 0x1001, Function: "app.load", File: "/app/load.go", Line: 10},
+		{PC: 0x2002, Function: "app.main", File: "/app/main.go", Line: 5},
+	}
+	for i := 0; i < 3; i++ {
+		s := p.sample(q, frames)
+		s.done(time.Millisecond, nil)
+		s.returned(2)
+	}
+	s := p.sample(newQueryInfo("select * from t where id = 2"), nil)
+	s.done(2*time.Millisecond, errors.New("failed"))
+	return p
+}
+
+func TestProfileCallers(t *testing.T) {
+	stats := testProfile().Snapshot()
+	if len(stats) != 1 {
+		t.Fatalf("got %d queries, want 1", len(stats))
+	}
+	s := stats[0]
+	if s.Query != "select * from t where id = ?" || s.Calls != 4 || s.Errors != 1 || s.RowsReturned != 6 {
+		t.Errorf("query stat = %+v", s)
+	}
+	// callers with the same application frames are one caller
+	if len(s.Callers) != 2 {
+		t.Fatalf("got %d callers, want 2", len(s.Callers))
+	}
+	// sorted by total time
+	c := s.Callers[0]
+	if c.Calls != 3 || c.Rows != 6 || len(c.Stack) != 2 || c.Stack[0] != "app.load /app/load.go:10" {
+		t.Errorf("caller stat = %+v", c)
+	}
+}
+
+func TestWriteProfile(t *testing.T) {
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool is not available")
+	}
+
+	path := filepath.Join(t.TempDir(), "sql.pb.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testProfile().WriteProfile(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out, err := exec.Command(gotool, "tool", "pprof", "-raw", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pprof failed: %v\n%s", err, out)
+	}
+	raw := string(out)
+	for _, want := range []string{
+		"calls/count latency/nanoseconds[dflt] rows/count errors/count",
+		"sql: select * from t where id = ?",
+		"app.load /app/load.go:10",
+		"app.main /app/main.go:5",
+		"fingerprint:[" + Fingerprint("select * from t where id = ?") + "]",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("pprof output has no %q:\n%s", want, raw)
+		}
+	}
+
+	samples := map[string]bool{}
+	for _, line := range strings.Split(raw, "\n") {
+		if i := strings.Index(line, ":"); i > 0 {
+			samples[strings.Join(strings.Fields(line[:i]), " ")] = true
+		}
+	}
+	for _, want := range []string{"3 3000000 6 0", "1 2000000 0 1"} {
+		if !samples[want] {
+			t.Errorf("pprof output has no sample %q:\n%s", want, raw)
+		}
+	}
+}
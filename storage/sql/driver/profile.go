@@ -0,0 +1,379 @@
+package driver
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// Profile aggregates statistics of queries executed through monitored
+// drivers by normalized query and its callers, similar to what pprof
+// does for call stacks. Profiling is enabled by setting Profile of
+// PluginConfig, profile can be served over http, e.g.
+//
+//	http.Handle("/debug/sqlprof", driver.DefaultProfile)
+//
+// and viewed with `go tool pprof http://host/debug/sqlprof`,
+// "?debug=1" shows top N table, "?format=json" all statistics
+type Profile struct {
+	mu         sync.Mutex
+	start      time.Time
+	queries    map[string]*queryStat
+	maxQueries int
+}
+
+type queryStat struct {
+	query       string
+	fingerprint string
+	latency     *gomon.Histogram
+
+	calls        int64
+	errors       int64
+	rowsReturned int64
+	rowsAffected int64
+	callers      map[stackKey]*callerStat
+}
+
+type callerStat struct {
+	// application frames, innermost first
+	frames []runtime.Frame
+
+	calls  int64
+	errors int64
+	rows   int64
+	total  time.Duration
+}
+
+// QueryStat is a snapshot of statistics of a normalized query
+type QueryStat struct {
+	Fingerprint  string        `json:"fingerprint"`
+	Query        string        `json:"query"`
+	Calls        int64         `json:"calls"`
+	Errors       int64         `json:"errors"`
+	Total        time.Duration `json:"total"`
+	Min          time.Duration `json:"min"`
+	Max          time.Duration `json:"max"`
+	P95          time.Duration `json:"p95"`
+	RowsReturned int64         `json:"rows_returned"`
+	RowsAffected int64         `json:"rows_affected"`
+	Callers      []CallerStat  `json:"callers"`
+}
+
+// CallerStat is a snapshot of calls of a query from a single call site
+type CallerStat struct {
+	Stack  []string      `json:"stack"`
+	Calls  int64         `json:"calls"`
+	Errors int64         `json:"errors"`
+	Rows   int64         `json:"rows"`
+	Total  time.Duration `json:"total"`
+}
+
+// profileSample is handle of a single query execution,
+// nil if profiling is disabled
+type profileSample struct {
+	p      *Profile
+	q      *queryStat
+	caller *callerStat
+}
+
+// program counters of query caller, callers are keyed
+// by their application frames only
+type stackKey [32]uintptr
+
+// DefaultProfile is not used unless it is set as Profile of config
+var DefaultProfile = NewProfile(1024)
+
+// queries and callers over the limits are aggregated together
+const (
+	kOtherQueries = "(other queries)"
+	kMaxCallers   = 64
+)
+
+var pkgPath = reflect.TypeOf(wrappedConn{}).PkgPath()
+
+// NewProfile creates profile which keeps up to maxQueries
+// normalized queries, the rest are counted as one
+func NewProfile(maxQueries int) *Profile {
+	return &Profile{
+		start:      time.Now(),
+		queries:    make(map[string]*queryStat),
+		maxQueries: maxQueries,
+	}
+}
+
+// Reset clears collected statistics
+func (p *Profile) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start = time.Now()
+	p.queries = make(map[string]*queryStat)
+}
+
+// startProfile must be called by the goroutine executing
+// query, caller stack is taken from it
+func (c *PluginConfig) startProfile(q *queryInfo) *profileSample {
+	if c.Profile == nil {
+		return nil
+	}
+	var frames []runtime.Frame
+	if c.ProfileStackDepth > 0 {
+		var stack stackKey
+		runtime.Callers(2, stack[:])
+		frames = appFrames(stack, c.ProfileStackDepth)
+	}
+	return c.Profile.sample(q, frames)
+}
+
+func (p *Profile) sample(q *queryInfo, frames []runtime.Frame) *profileSample {
+	// the same call site reached through different
+	// database/sql paths is the same caller
+	var key stackKey
+	for i, f := range frames {
+		key[i] = f.PC
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fp, query := q.fingerprint, q.normalized
+	qs, ok := p.queries[fp]
+	if !ok && p.maxQueries > 0 && len(p.queries) >= p.maxQueries {
+		fp, query = kOtherQueries, kOtherQueries
+		qs, ok = p.queries[fp]
+	}
+	if !ok {
+		qs = &queryStat{
+			query:       query,
+			fingerprint: fp,
+			latency:     gomon.NewHistogram(nil),
+			callers:     make(map[stackKey]*callerStat),
+		}
+		p.queries[fp] = qs
+	}
+
+	cs, ok := qs.callers[key]
+	if !ok && len(qs.callers) >= kMaxCallers {
+		// unknown caller
+		key, frames = stackKey{}, nil
+		cs, ok = qs.callers[key]
+	}
+	if !ok {
+		cs = &callerStat{frames: frames}
+		qs.callers[key] = cs
+	}
+	return &profileSample{p: p, q: qs, caller: cs}
+}
+
+// done records execution of the query, driver.ErrSkip means
+// query was not executed and database/sql retries it another way
+func (s *profileSample) done(d time.Duration, err error) {
+	if s == nil || err == driver.ErrSkip {
+		return
+	}
+	s.q.latency.Observe(d)
+
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	s.q.calls++
+	s.caller.calls++
+	s.caller.total += d
+	if err != nil {
+		s.q.errors++
+		s.caller.errors++
+	}
+}
+
+func (s *profileSample) returned(rows int64) {
+	if s == nil {
+		return
+	}
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	s.q.rowsReturned += rows
+	s.caller.rows += rows
+}
+
+func (s *profileSample) affected(rows int64) {
+	if s == nil {
+		return
+	}
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	s.q.rowsAffected += rows
+	s.caller.rows += rows
+}
+
+// appFrames returns up to depth frames of application code,
+// i.e. frames above (called before) database/sql and this package
+func appFrames(stack stackKey, depth int) []runtime.Frame {
+	n := 0
+	for n < len(stack) && stack[n] != 0 {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	var frames []runtime.Frame
+	it := runtime.CallersFrames(stack[:n])
+	for {
+		frame, more := it.Next()
+		if isInternalFrame(frame.Function) {
+			// everything seen so far was called by database/sql
+			frames = frames[:0]
+		} else if !strings.HasPrefix(frame.Function, "runtime.") {
+			frames = append(frames, frame)
+		}
+		if !more {
+			break
+		}
+	}
+	if len(frames) > depth {
+		frames = frames[:depth]
+	}
+	return frames
+}
+
+func isInternalFrame(function string) bool {
+	return strings.HasPrefix(function, "database/sql.") ||
+		strings.HasPrefix(function, pkgPath+".")
+}
+
+// Snapshot returns statistics of queries sorted by total time
+func (p *Profile) Snapshot() []QueryStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]QueryStat, 0, len(p.queries))
+	for _, qs := range p.queries {
+		kv := qs.latency.KVData()
+		stat := QueryStat{
+			Fingerprint:  qs.fingerprint,
+			Query:        qs.query,
+			Calls:        qs.calls,
+			Errors:       qs.errors,
+			Total:        kv["sum"].(time.Duration),
+			Min:          kv["min"].(time.Duration),
+			Max:          kv["max"].(time.Duration),
+			P95:          kv["p95"].(time.Duration),
+			RowsReturned: qs.rowsReturned,
+			RowsAffected: qs.rowsAffected,
+		}
+		for _, cs := range qs.callers {
+			if cs.calls == 0 {
+				continue
+			}
+			stat.Callers = append(stat.Callers, CallerStat{
+				Stack:  formatFrames(cs.frames),
+				Calls:  cs.calls,
+				Errors: cs.errors,
+				Rows:   cs.rows,
+				Total:  cs.total,
+			})
+		}
+		sort.Slice(stat.Callers, func(i, j int) bool {
+			return stat.Callers[i].Total > stat.Callers[j].Total
+		})
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Total > stats[j].Total
+	})
+	return stats
+}
+
+func formatFrames(frames []runtime.Frame) []string {
+	stack := make([]string, 0, len(frames))
+	for _, f := range frames {
+		stack = append(stack, f.Function+" "+f.File+":"+strconv.Itoa(f.Line))
+	}
+	return stack
+}
+
+// ServeHTTP writes profile in pprof format, top N queries as text
+// table if debug=1 (n=<count>, sort=calls|errors|max|p95|rows|total)
+// or all statistics as JSON if format=json
+func (p *Profile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	switch {
+	case r.FormValue("format") == "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(p.Snapshot()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case r.FormValue("debug") == "1":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		n, err := strconv.Atoi(r.FormValue("n"))
+		if err != nil || n <= 0 {
+			n = 20
+		}
+		p.writeTop(w, n, r.FormValue("sort"))
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="sql.pb.gz"`)
+		if err := p.WriteProfile(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+var profileSortKeys = map[string]func(s *QueryStat) int64{
+	"calls":  func(s *QueryStat) int64 { return s.Calls },
+	"errors": func(s *QueryStat) int64 { return s.Errors },
+	"max":    func(s *QueryStat) int64 { return int64(s.Max) },
+	"p95":    func(s *QueryStat) int64 { return int64(s.P95) },
+	"rows":   func(s *QueryStat) int64 { return s.RowsReturned + s.RowsAffected },
+	"total":  func(s *QueryStat) int64 { return int64(s.Total) },
+}
+
+func (p *Profile) writeTop(w io.Writer, n int, sortBy string) {
+	stats := p.Snapshot()
+	if key, ok := profileSortKeys[sortBy]; ok {
+		sort.SliceStable(stats, func(i, j int) bool {
+			return key(&stats[i]) > key(&stats[j])
+		})
+	}
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+
+	p.mu.Lock()
+	since := p.start
+	p.mu.Unlock()
+	fmt.Fprintf(w, "sql profile since %s (%s)\n\n", since.Format(time.RFC3339), time.Since(since))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "calls\terrors\ttotal\tavg\tmin\tmax\tp95\trows\taffected\tfingerprint\tquery")
+	for _, s := range stats {
+		var avg time.Duration
+		if s.Calls > 0 {
+			avg = s.Total / time.Duration(s.Calls)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			s.Calls, s.Errors, s.Total, avg, s.Min, s.Max, s.P95,
+			s.RowsReturned, s.RowsAffected, s.Fingerprint, s.Query)
+	}
+	tw.Flush()
+
+	for _, s := range stats {
+		fmt.Fprintf(w, "\n%s %s\n", s.Fingerprint, s.Query)
+		for _, c := range s.Callers {
+			fmt.Fprintf(w, "  %d calls, %s, %d rows, %d errors\n", c.Calls, c.Total, c.Rows, c.Errors)
+			for _, frame := range c.Stack {
+				fmt.Fprintf(w, "    %s\n", frame)
+			}
+		}
+	}
+}
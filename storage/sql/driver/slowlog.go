@@ -0,0 +1,277 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iahmedov/gomon"
+)
+
+// ExplainFunc is called for slow queries with a connection which is not
+// used by application (nor monitored), returned output is added to
+// slow query event. args are values query was executed with
+type ExplainFunc func(ctx context.Context, conn *sql.Conn, query string, args []interface{}) (string, error)
+
+// explainer runs ExplainFunc on connections of its own pool,
+// one query at a time and at most once a kExplainInterval
+// for the same normalized query
+type explainer struct {
+	db      *sql.DB
+	running int32
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// opens connections of explainer with unmonitored driver
+type explainConnector struct {
+	parent driver.Driver
+	name   string
+}
+
+var (
+	KeySlowQueryDuration = "duration"
+	KeyCallerStack       = "caller-stack"
+	KeyExplain           = "explain"
+	KeyExplainError      = "explain-error"
+)
+
+const (
+	kExplainTimeout  = 10 * time.Second
+	kExplainInterval = time.Minute
+)
+
+// Explain returns ExplainFunc which executes query prefixed with
+// prefix (e.g. "EXPLAIN ") and returns tab separated columns of result
+// rows, one row per line. Note that "EXPLAIN ANALYZE" executes query
+func Explain(prefix string) ExplainFunc {
+	return func(ctx context.Context, conn *sql.Conn, query string, args []interface{}) (string, error) {
+		rows, err := conn.QueryContext(ctx, prefix+query, args...)
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+
+		cols, err := rows.Columns()
+		if err != nil {
+			return "", err
+		}
+		values := make([]sql.NullString, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		var lines []string
+		fields := make([]string, len(cols))
+		for rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				return "", err
+			}
+			for i, v := range values {
+				fields[i] = v.String
+			}
+			lines = append(lines, strings.Join(fields, "\t"))
+		}
+		return strings.Join(lines, "\n"), rows.Err()
+	}
+}
+
+func (c *explainConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.parent.Open(c.name)
+}
+
+func (c *explainConnector) Driver() driver.Driver {
+	return c.parent
+}
+
+// explainer returns explainer of connections opened with name
+func (wdr *wrappedDriver) explainer(name string) *explainer {
+	wdr.mu.Lock()
+	defer wdr.mu.Unlock()
+	if e, ok := wdr.explainers[name]; ok {
+		return e
+	}
+
+	db := sql.OpenDB(&explainConnector{wdr.parent, name})
+	// connection is not kept between slow queries
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(0)
+
+	e := &explainer{
+		db:   db,
+		last: make(map[string]time.Time),
+	}
+	if wdr.explainers == nil {
+		wdr.explainers = make(map[string]*explainer)
+	}
+	wdr.explainers[name] = e
+	return e
+}
+
+// slowQuery sends "sql-slow-query" event if query took longer than
+// SlowQueryThreshold, it must be called by the goroutine which executed
+// query, so that application call site can be taken from its stack.
+// Event has no raw query text, its literals would defeat params redaction
+func (wcn *wrappedConn) slowQuery(et gomon.EventTracker, q *queryInfo, args []driver.NamedValue, err error) {
+	if !wcn.isSlow(et, err) {
+		return
+	}
+	wcn.reportSlowQuery(et, q, args, err)
+}
+
+// slowQueryValues is slowQuery for []driver.Value args, they are
+// converted only if query is slow
+func (wcn *wrappedConn) slowQueryValues(et gomon.EventTracker, q *queryInfo, args []driver.Value, err error) {
+	if !wcn.isSlow(et, err) {
+		return
+	}
+	wcn.reportSlowQuery(et, q, valuesToNamed(args), err)
+}
+
+func (wcn *wrappedConn) isSlow(et gomon.EventTracker, err error) bool {
+	c := wcn.c
+	return c.SlowQueryThreshold > 0 && et.Lapsed() >= c.SlowQueryThreshold && err != driver.ErrSkip
+}
+
+func (wcn *wrappedConn) reportSlowQuery(et gomon.EventTracker, q *queryInfo, args []driver.NamedValue, err error) {
+	c := wcn.c
+	lapsed := et.Lapsed()
+
+	var stack stackKey
+	runtime.Callers(2, stack[:])
+
+	slow := et.NewChild(false)
+	slow.SetFingerprint("sql-slow-query")
+	q.fillNormalized(slow, c)
+	slow.Set(KeySlowQueryDuration, lapsed)
+	slow.Set(KeyCallerStack, formatFrames(appFrames(stack, len(stack))))
+	fillParams(slow, args, c.RedactParam)
+	if err != nil {
+		slow.AddError(err)
+	}
+
+	if c.Explain == nil || !wcn.d.explainer(wcn.name).start(slow, q, args, c.Explain) {
+		slow.Finish()
+	}
+}
+
+// start runs explain in background and finishes et when it is done,
+// false if explain is skipped (another one is running or the same
+// query was explained recently)
+func (e *explainer) start(et gomon.EventTracker, q *queryInfo, args []driver.NamedValue, explain ExplainFunc) bool {
+	if !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+		return false
+	}
+
+	now := time.Now()
+	e.mu.Lock()
+	recent := now.Sub(e.last[q.fingerprint]) < kExplainInterval
+	if !recent {
+		e.last[q.fingerprint] = now
+		for fp, t := range e.last {
+			if now.Sub(t) >= kExplainInterval {
+				delete(e.last, fp)
+			}
+		}
+	}
+	e.mu.Unlock()
+	if recent {
+		atomic.StoreInt32(&e.running, 0)
+		return false
+	}
+
+	// application may reuse its buffers once query returned
+	values := explainArgs(args)
+	go func() {
+		defer atomic.StoreInt32(&e.running, 0)
+		defer et.Finish()
+
+		ctx, cancel := context.WithTimeout(context.Background(), kExplainTimeout)
+		defer cancel()
+		conn, err := e.db.Conn(ctx)
+		if err != nil {
+			et.Set(KeyExplainError, err.Error())
+			return
+		}
+		defer conn.Close()
+
+		output, err := explain(ctx, conn, q.raw, values)
+		if err != nil {
+			et.Set(KeyExplainError, err.Error())
+		}
+		if len(output) > 0 {
+			et.Set(KeyExplain, output)
+		}
+	}()
+	return true
+}
+
+func explainArgs(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		v := arg.Value
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+		if len(arg.Name) > 0 {
+			v = sql.Named(arg.Name, v)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func fillParams(et gomon.EventTracker, args []driver.NamedValue, redact func(driver.NamedValue) interface{}) {
+	if redact == nil {
+		redact = redactParam
+	}
+
+	var params []interface{}
+	var named map[string]interface{}
+	for _, arg := range args {
+		if len(arg.Name) == 0 {
+			params = append(params, redact(arg))
+			continue
+		}
+		if named == nil {
+			named = make(map[string]interface{})
+		}
+		named[arg.Name] = redact(arg)
+	}
+	if params != nil {
+		et.Set(KeyParams, params)
+	}
+	if named != nil {
+		et.Set(KeyNamedParams, named)
+	}
+}
+
+// redactParam hides value, only its type and length are shown
+func redactParam(arg driver.NamedValue) interface{} {
+	switch v := arg.Value.(type) {
+	case nil:
+		return nil
+	case string:
+		return fmt.Sprintf("<string len=%d>", len(v))
+	case []byte:
+		return fmt.Sprintf("<[]byte len=%d>", len(v))
+	}
+	return fmt.Sprintf("<%T>", arg.Value)
+}
+
+// valuesToNamed is reverse of namedValueToValue
+func valuesToNamed(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for n, v := range args {
+		named[n] = driver.NamedValue{Ordinal: n + 1, Value: v}
+	}
+	return named
+}
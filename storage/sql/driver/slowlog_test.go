@@ -0,0 +1,158 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver opens connections which take 30ms for queries
+// containing "slow", every opened connection is kept
+type fakeDriver struct {
+	mu    sync.Mutex
+	conns []*fakeConn
+}
+
+type fakeConn struct{}
+
+type fakeRows struct{}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	c := &fakeConn{}
+	d.mu.Lock()
+	d.conns = append(d.conns, c)
+	d.mu.Unlock()
+	return c, nil
+}
+
+func (d *fakeDriver) opened() []*fakeConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*fakeConn(nil), d.conns...)
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if strings.Contains(query, "slow") {
+		time.Sleep(30 * time.Millisecond)
+	}
+	return fakeRows{}, nil
+}
+
+func (fakeRows) Columns() []string              { return []string{"n"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func openSlowLogDB(t *testing.T, conf *PluginConfig) (*sql.DB, *fakeDriver) {
+	events.reset()
+	d := &fakeDriver{}
+	// explainConnector opens connections of any driver by name
+	db := sql.OpenDB(&explainConnector{&wrappedDriver{parent: d, c: conf}, "test"})
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+func query(t *testing.T, db *sql.DB, q string, args ...interface{}) {
+	t.Helper()
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	db, _ := openSlowLogDB(t, &PluginConfig{SlowQueryThreshold: 20 * time.Millisecond})
+	query(t, db, "select 1 from fast")
+	query(t, db, "select 1 from slow where id = 5")
+
+	slow := events.wait(t, "sql-slow-query", 1)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(events.wait(t, "sql-slow-query", 1)); n != 1 {
+		t.Fatalf("got %d slow queries, want 1", n)
+	}
+	et := slow[0]
+	if q := et.Get(KeyNormalizedQuery); q != "select ? from slow where id = ?" {
+		t.Errorf("normalized query = %v", q)
+	}
+	if d, _ := et.Get(KeySlowQueryDuration).(time.Duration); d < 20*time.Millisecond {
+		t.Errorf("duration = %v, want at least threshold", d)
+	}
+	// literals of raw text would defeat params redaction
+	if q := et.Get(KeyQuery); q != nil {
+		t.Errorf("slow query event has raw query %v", q)
+	}
+}
+
+func TestSlowQueryDisabled(t *testing.T) {
+	db, _ := openSlowLogDB(t, &PluginConfig{})
+	query(t, db, "select 1 from slow")
+	events.wait(t, "sql-wconn-queryctx", 1)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(events.wait(t, "sql-slow-query", 0)); n != 0 {
+		t.Errorf("got %d slow queries with zero threshold", n)
+	}
+}
+
+func TestSlowQueryRedactedParams(t *testing.T) {
+	db, _ := openSlowLogDB(t, &PluginConfig{SlowQueryThreshold: time.Millisecond})
+	query(t, db, "select 1 from slow where a = ? and b = ? and c = ? and d = ?",
+		"secret", 42, []byte("key"), nil)
+
+	params, _ := events.wait(t, "sql-slow-query", 1)[0].Get(KeyParams).([]interface{})
+	want := []interface{}{"<string len=6>", "<int64>", "<[]byte len=3>", nil}
+	if len(params) != len(want) {
+		t.Fatalf("params = %v, want %v", params, want)
+	}
+	for i := range want {
+		if params[i] != want[i] {
+			t.Errorf("param %d = %v, want %v", i, params[i], want[i])
+		}
+	}
+}
+
+func TestSlowQueryExplain(t *testing.T) {
+	explained := make(chan interface{}, 1)
+	explain := func(ctx context.Context, conn *sql.Conn, query string, args []interface{}) (string, error) {
+		err := conn.Raw(func(dc interface{}) error {
+			explained <- dc
+			return nil
+		})
+		return "plan of " + query, err
+	}
+	db, d := openSlowLogDB(t, &PluginConfig{SlowQueryThreshold: time.Millisecond, Explain: explain})
+	query(t, db, "select 1 from slow where id = 5")
+
+	et := events.wait(t, "sql-slow-query", 1)[0]
+	if plan := et.Get(KeyExplain); plan != "plan of select 1 from slow where id = 5" {
+		t.Errorf("explain = %v", plan)
+	}
+
+	dc := <-explained
+	conn, ok := dc.(*fakeConn)
+	if !ok {
+		t.Fatalf("explain connection is %T, want unmonitored driver connection", dc)
+	}
+	conns := d.opened()
+	if len(conns) != 2 || conns[1] != conn {
+		t.Errorf("explain is not executed on a separate connection: %d opened", len(conns))
+	}
+}
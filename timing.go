@@ -0,0 +1,83 @@
+package gomon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Timings aggregates durations of finished trackers by category
+// (db, http, ...) for a single unit of work, for example
+// an incoming http request. Plugins add their lapsed time
+// when context of the unit of work contains Timings
+type Timings struct {
+	start time.Time
+
+	mu      sync.Mutex
+	order   []string
+	entries map[string]*timingEntry
+}
+
+type timingEntry struct {
+	lapsed time.Duration
+	count  int
+}
+
+type timingsKey struct{}
+
+func NewTimings() *Timings {
+	return &Timings{
+		start:   time.Now(),
+		entries: make(map[string]*timingEntry),
+	}
+}
+
+func WithTimings(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, timingsKey{}, t)
+}
+
+// TimingsFromContext returns nil if context has no Timings
+func TimingsFromContext(ctx context.Context) *Timings {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// AddTiming is no-op when context has no Timings
+func AddTiming(ctx context.Context, name string, lapsed time.Duration) {
+	TimingsFromContext(ctx).Add(name, lapsed)
+}
+
+func (t *Timings) Add(name string, lapsed time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[name]
+	if !ok {
+		e = &timingEntry{}
+		t.entries[name] = e
+		t.order = append(t.order, name)
+	}
+	e.lapsed += lapsed
+	e.count++
+}
+
+// Total returns time passed since Timings was created
+func (t *Timings) Total() time.Duration {
+	return time.Since(t.start)
+}
+
+// Each calls fn for every category in order they were added
+func (t *Timings) Each(fn func(name string, lapsed time.Duration, count int)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range t.order {
+		e := t.entries[name]
+		fn(name, e.lapsed, e.count)
+	}
+}